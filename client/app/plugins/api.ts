@@ -4,33 +4,72 @@ export default defineNuxtPlugin((nuxtApp) => {
     const config = useRuntimeConfig()
     const { $toast } = useNuxtApp()
     const { parseError } = useErrorParser()
+    const token = useCookie('token')
+    const refreshToken = useCookie('refresh_token')
 
-    const api = $fetch.create({
+    const request = $fetch.create({
         baseURL: config.public.apiBase,
 
         onRequest({ options }) {
-            const token = useCookie('token')
             if (token.value) {
                 // Ensure headers is a Headers object
                 const headers = new Headers(options.headers)
                 headers.set('Authorization', `Bearer ${token.value}`)
                 options.headers = headers
             }
-        },
-        
-        onResponseError({ response }) {
-            if (response.status === 401) {
-                const token = useCookie('token')
-                token.value = null
-                $toast?.error(parseError("Your session has expired. Please log in again."))
-                navigateTo('/auth/login')
-            }
         }
     })
 
+    // Access tokens are short-lived. Concurrent 401s share one refresh call,
+    // since a refresh token only works once.
+    let refreshing: Promise<boolean> | null = null
+    const refresh = () => {
+        refreshing ??= $fetch<any>('/auth/refresh', {
+            baseURL: config.public.apiBase,
+            method: 'POST',
+            body: { refresh_token: refreshToken.value }
+        })
+            .then((response) => {
+                token.value = response.data.token
+                refreshToken.value = response.data.refresh_token
+                return true
+            })
+            .catch(() => false)
+            .finally(() => {
+                refreshing = null
+            })
+        return refreshing
+    }
+
+    // Retry a request once with a fresh access token, otherwise end the session
+    const withRefresh = async <T>(url: string, send: () => Promise<T>): Promise<T> => {
+        try {
+            return await send()
+        } catch (error: any) {
+            if (error.response?.status !== 401 || url.startsWith('/auth/')) {
+                throw error
+            }
+
+            if (refreshToken.value && await refresh()) {
+                return await send()
+            }
+
+            token.value = null
+            refreshToken.value = null
+            $toast?.error(parseError("Your session has expired. Please log in again."))
+            navigateTo('/auth/login')
+            throw error
+        }
+    }
+
+    const api = ((url: string, options?: any) => withRefresh(url, () => request(url, options))) as typeof request
+    api.raw = ((url: string, options?: any) => withRefresh(url, () => request.raw(url, options))) as typeof request.raw
+    api.native = request.native
+    api.create = request.create
+
     return {
         provide: {
             api
         }
     }
-})
\ No newline at end of file
+})
@@ -4,6 +4,8 @@ import type { ApiResponse } from '~/types/response'
 interface User {
     id: string
     email: string
+    mfa_enabled: boolean
+    email_verified: boolean
     created_at: string
 }
 
@@ -11,6 +13,7 @@ export const useAuthStore = defineStore('auth', () => {
     // Injections
     const { $api, $toast } = useNuxtApp()
     const token = useCookie('token')
+    const refreshToken = useCookie('refresh_token')
     // State
     const user = ref<User | null>(null)
     const isLoading = ref(false)
@@ -22,19 +25,41 @@ export const useAuthStore = defineStore('auth', () => {
         })
     }
 
-    const login = async (credentials: any) => {
-        const response = await $api<{ success: boolean, data: { token: string } }>('/auth/login', {
+    // Resolves to an MFA token when the account needs a second step, see verifyMfa
+    const login = async (credentials: any): Promise<string | null> => {
+        const response = await $api<{ success: boolean, data: { token?: string, refresh_token?: string, mfa_required?: boolean, mfa_token?: string } }>('/auth/login', {
         method: 'POST',
         body: credentials
         })
+        if (response.success && response.data.mfa_required) {
+            return response.data.mfa_token ?? null
+        }
         if (response.success && response.data.token) {
-            token.value = response.data.token
-            // Wait for cookie to sync before fetching user
-            await nextTick()
-            await fetchUser()
+            await startSession(response.data.token, response.data.refresh_token)
+        }
+        return null
+    }
+
+    const verifyMfa = async (mfaToken: string, code: string) => {
+        // Recovery codes contain dashes or letters, authenticator codes are six digits
+        const isTotp = /^\d{6}$/.test(code.replace(/\s/g, ''))
+        const response = await $api<{ success: boolean, data: { token: string, refresh_token: string } }>('/auth/mfa/verify', {
+            method: 'POST',
+            body: isTotp ? { mfa_token: mfaToken, code } : { mfa_token: mfaToken, recovery_code: code }
+        })
+        if (response.success && response.data.token) {
+            await startSession(response.data.token, response.data.refresh_token)
         }
     }
 
+    const startSession = async (accessToken: string, newRefreshToken?: string) => {
+        token.value = accessToken
+        refreshToken.value = newRefreshToken ?? null
+        // Wait for cookie to sync before fetching user
+        await nextTick()
+        await fetchUser()
+    }
+
     const fetchUser = async () => {
         if (!token.value) return
 
@@ -45,16 +70,64 @@ export const useAuthStore = defineStore('auth', () => {
             }
         } catch (error) {
             token.value = null
+            refreshToken.value = null
             user.value = null
             $toast.error('Session expired. Please log in again.')
         }
     }
 
-    const logout = () => {
+    const logout = async () => {
+        // Revoke the session server side, the local state is cleared either way
+        if (token.value) {
+            await $api('/auth/logout', {
+                method: 'POST',
+                body: { refresh_token: refreshToken.value || undefined }
+            }).catch(() => {})
+        }
         token.value = null
+        refreshToken.value = null
         user.value = null
     }
 
+    const forgotPassword = async (email: string) => {
+        await $api('/auth/forgot-password', {
+            method: 'POST',
+            body: { email }
+        })
+    }
+
+    const resetPassword = async (resetToken: string, password: string) => {
+        await $api('/auth/reset-password', {
+            method: 'POST',
+            body: { token: resetToken, password }
+        })
+    }
+
+    const verifyEmail = async (verifyToken: string) => {
+        await $api('/auth/verify-email', {
+            method: 'POST',
+            body: { token: verifyToken }
+        })
+        if (user.value) {
+            user.value.email_verified = true
+        }
+    }
+
+    const resendVerification = async () => {
+        await $api('/auth/verify-email/resend', { method: 'POST' })
+    }
+
+    // Every other session is signed out, this one continues with the returned tokens
+    const changePassword = async (currentPassword: string, newPassword: string) => {
+        const response = await $api<{ success: boolean, data: { token: string, refresh_token: string } }>('/auth/change-password', {
+            method: 'POST',
+            body: { current_password: currentPassword, new_password: newPassword }
+        })
+        if (response.success && response.data.token) {
+            await startSession(response.data.token, response.data.refresh_token)
+        }
+    }
+
     // Initialize
     const initAuth = async () => {
         if (token.value && !user.value) {
@@ -68,6 +141,12 @@ export const useAuthStore = defineStore('auth', () => {
         isLoading,
         register,
         login,
+        verifyMfa,
+        forgotPassword,
+        resetPassword,
+        verifyEmail,
+        resendVerification,
+        changePassword,
         logout,
         fetchUser,
         initAuth
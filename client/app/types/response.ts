@@ -1,3 +1,18 @@
 export type ApiResponse<T> = 
     | { success: true; data: T; message?: never; error?: never }
-    | { success: false; error: string; data?: never }
\ No newline at end of file
+    | { success: false; error: string; code?: ApiErrorCode; data?: never }
+
+// Machine readable error codes, branch on these instead of the error text
+export type ApiErrorCode =
+    | 'not_found'
+    | 'expired'
+    | 'burnt'
+    | 'revoked'
+    | 'passphrase_required'
+    | 'wrong_passphrase'
+    | 'login_required'
+    | 'forbidden'
+    | 'invalid_reveal_token'
+    | 'invalid_input'
+    | 'internal_error'
+    | (string & {})
\ No newline at end of file
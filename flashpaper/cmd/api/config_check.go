@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/direwen/flashpaper/internal/config"
+)
+
+const configUsage = "usage: main config check"
+
+// runConfig is the "config" subcommand: check loads and validates the
+// configuration like the server would, without starting it. Every problem is
+// printed, and the exit status is 1 when there is any.
+func runConfig(cfg *config.Config, loadErr error, args []string) {
+	if len(args) != 1 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		os.Exit(2)
+	}
+
+	problems := errors.Join(loadErr, cfg.Validate())
+	if problems == nil {
+		fmt.Println("Configuration is valid")
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Configuration problems:")
+	for _, problem := range unwrapJoined(problems) {
+		fmt.Fprintln(os.Stderr, "  - "+problem.Error())
+	}
+	os.Exit(1)
+}
+
+// unwrapJoined flattens errors.Join trees into their leaves
+func unwrapJoined(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var leaves []error
+	for _, inner := range joined.Unwrap() {
+		leaves = append(leaves, unwrapJoined(inner)...)
+	}
+	return leaves
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,54 +18,196 @@ import (
 
 	"github.com/direwen/flashpaper/internal/config"
 	"github.com/direwen/flashpaper/internal/handlers"
+	"github.com/direwen/flashpaper/internal/logging"
+	"github.com/direwen/flashpaper/internal/mail"
+	"github.com/direwen/flashpaper/internal/metrics"
 	"github.com/direwen/flashpaper/internal/middleware"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/ratelimit"
+	"github.com/direwen/flashpaper/internal/repository"
 	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/internal/storage"
 	"github.com/direwen/flashpaper/internal/tasks"
+	"github.com/direwen/flashpaper/internal/tracing"
+	"github.com/direwen/flashpaper/pkg/utils"
 )
 
 func main() {
 	// Load Env Variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	envErr := godotenv.Load()
+
+	// Typed configuration from defaults, the config file, the environment and
+	// flags. Flags go before a subcommand: main -config flashpaper.yaml migrate up
+	cfg, args, loadErr := config.Load(os.Args[1:])
+	if cfg == nil {
+		if errors.Is(loadErr, flag.ErrHelp) {
+			return
+		}
+		logging.Fatal("Failed to load configuration", "error", loadErr)
+	}
+
+	// "main config check" reports every problem and exits, see config_check.go
+	if len(args) > 0 && args[0] == "config" {
+		runConfig(cfg, loadErr, args[1:])
+		return
+	}
+	if loadErr != nil {
+		logging.Fatal("Invalid configuration", "error", loadErr)
+	}
+
+	// Structured logs (LOG_LEVEL, LOG_FORMAT), everything below logs through slog
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Fatal("Failed to set up logging", "error", err)
+	}
+	if envErr != nil {
+		slog.Info("No .env file found")
+	}
+
+	// "main migrate ..." manages the schema and exits, see migrate.go
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			fmt.Fprintln(os.Stderr, "usage: main [flags] [migrate ... | config check]")
+			os.Exit(2)
+		}
+		runMigrate(cfg.Database, args[1:])
+		return
+	}
+
+	// Every setting is checked before anything starts, not on first use
+	if err := cfg.Validate(); err != nil {
+		logging.Fatal("Invalid configuration, run \"main config check\" for the full list", "error", err)
+	}
+
+	// Load Encryption Keyring and token settings
+	if err := utils.LoadKeyring(cfg.Security.EncryptionKeys, cfg.Security.EncryptionActiveKey, cfg.Security.EncryptionKey); err != nil {
+		logging.Fatal("Failed to load encryption keys", "error", err)
+	}
+	utils.ConfigureTokens(utils.TokenConfig{
+		Secret:     cfg.Security.JWTSecret,
+		AccessTTL:  cfg.Tokens.Access,
+		RefreshTTL: cfg.Tokens.Refresh,
+		RevealTTL:  cfg.Tokens.Reveal,
+	})
+
+	// Optional OpenTelemetry tracing (TRACING_EXPORTER), off unless configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
 
 	// Init Database Connection
-	config.ConnectDB()
+	config.ConnectDB(cfg.Database)
 	db := config.GetDB()
 
 	// Health Check: Database Connection
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal("Failed to get database instance: ", err)
+		logging.Fatal("Failed to get database instance", "error", err)
 	}
 	if err := sqlDB.Ping(); err != nil {
-		log.Fatal("Failed to ping database: ", err)
+		logging.Fatal("Failed to ping database", "error", err)
+	}
+	slog.Info("Database connection verified")
+
+	// Export the connection pool stats on /metrics
+	if err := metrics.RegisterDB(sqlDB); err != nil {
+		logging.Fatal("Failed to register database metrics", "error", err)
+	}
+
+	// Bring the schema up to date, unless deploys run "migrate up" as their own step
+	if cfg.Database.MigrateOnBoot {
+		migrateOnBoot(db)
 	}
-	log.Println("Database connection verified.")
 
-	// Start Background Task
-	tasks.StartJanitor()
+	// Snippets and users go through repositories, on whichever SQL backend DB_DRIVER picked
+	repos := repository.NewGorm(db)
+
+	// Init Blob Storage for file snippets
+	blobs, err := storage.NewLocalBlobStore(cfg.Storage.BlobPath)
+	if err != nil {
+		logging.Fatal("Failed to init blob storage", "error", err)
+	}
+
+	// Start Background Tasks, they stop with tasksCtx on shutdown
+	tasksCtx, stopTasks := context.WithCancel(context.Background())
+	janitorDone := tasks.StartJanitor(tasksCtx, repos, blobs, tasks.JanitorConfig{
+		Interval:         cfg.Tasks.JanitorInterval,
+		BatchSize:        cfg.Tasks.JanitorBatchSize,
+		BurntGrace:       cfg.Tasks.JanitorBurntGrace,
+		HistoryRetention: cfg.Tasks.HistoryRetention,
+		RetiredBlobGrace: cfg.Tasks.RetiredBlobGrace,
+	})
+	rekeyDone := tasks.StartKeyRotation(tasksCtx, repos, blobs, cfg.Tasks.RekeyInterval, cfg.Tasks.RekeyBatchSize)
+	webhooksDone := tasks.StartWebhookDispatcher(tasksCtx, cfg.Webhooks.Interval, cfg.Webhooks.MaxAttempts, cfg.Webhooks.AllowInsecure)
+
+	// Init Mailer (log mailer unless SMTP is configured)
+	var mailer mail.Mailer
+	switch cfg.Mail.Mailer {
+	case "smtp":
+		mailer, err = mail.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword, cfg.Mail.From)
+	default:
+		mailer, err = mail.NewLogMailer(cfg.Mail.Dir)
+	}
+	if err != nil {
+		logging.Fatal("Failed to init mailer", "error", err)
+	}
 
 	// Init Layers
-	authService := services.NewAuthService(db)
+	authService := services.NewAuthService(db, repos, mailer, services.AuthConfig{
+		ClientURL:            cfg.Server.ClientURL,
+		MFATokenTTL:          cfg.Tokens.MFA,
+		EmailVerificationTTL: cfg.Tokens.EmailVerification,
+		PasswordResetTTL:     cfg.Tokens.PasswordReset,
+	})
 	authHandler := handlers.NewAuthHandler(authService)
-	snippetService := services.NewSnippetService(db)
-	snippetHandler := handlers.NewSnippetHandler(snippetService)
+	snippetService := services.NewSnippetService(db, repos, blobs)
+	snippetHandler := handlers.NewSnippetHandler(snippetService, cfg.Server.MaxUploadSize)
+	webhookService := services.NewWebhookService(db, cfg.Webhooks.AllowInsecure)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	apiTokenService := services.NewAPITokenService(db)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenService)
+	healthService := services.NewHealthService(sqlDB, services.HealthConfig{
+		PingTimeout:        cfg.Server.ReadinessTimeout,
+		JanitorInterval:    cfg.Tasks.JanitorInterval,
+		JanitorLastSuccess: tasks.JanitorLastSuccess,
+		JanitorStandby:     tasks.JanitorStandby,
+	})
+	healthHandler := handlers.NewHealthHandler(healthService)
 
-	// Init Gin Router
-	r := gin.Default()
-	// Cors Config
-	config := cors.DefaultConfig()
+	// Rate limits are counted per instance unless they share a Redis
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.RedisURL != "" {
+		limits, err = ratelimit.NewRedisStoreFromURL(cfg.RateLimit.RedisURL)
+		if err != nil {
+			logging.Fatal("Failed to init rate limit store", "error", err)
+		}
+	}
+	// Password guessing and mail sending are the expensive ones to leave open
+	authLimit := middleware.RateLimit(limits, "auth", ratelimit.PerMinute(10), middleware.ByIP)
+	mailLimit := middleware.RateLimit(limits, "mail", ratelimit.PerHour(5), middleware.ByIP)
+	publicLimit := middleware.RateLimit(limits, "public", ratelimit.PerMinute(60), middleware.ByIP)
+	accountLimit := middleware.RateLimit(limits, "account", ratelimit.PerMinute(300), middleware.ByAccount)
 
-	config.AllowOrigins = []string{"http://localhost:3000"}
-	if clientURL := os.Getenv("CLIENT_URL"); clientURL != "" {
-		config.AllowOrigins = append(config.AllowOrigins, clientURL)
+	// Init Gin Router (our own access log and recovery keep secrets out of the logs)
+	r := gin.New()
+	r.Use(middleware.RequestID(), middleware.Tracing(), middleware.AccessLog(), middleware.Metrics(), middleware.Recovery())
+	// Client IPs (and so the per-IP limits) only honour X-Forwarded-For from these proxies
+	if len(cfg.Server.TrustedProxies) > 0 {
+		if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+			logging.Fatal("Invalid TRUSTED_PROXIES", "error", err)
+		}
 	}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
-	r.Use(cors.New(config))
+	// Cors Config
+	corsConfig := cors.DefaultConfig()
+
+	corsConfig.AllowOrigins = cfg.Server.CORSOrigins
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", middleware.RequestIDHeader}
+	corsConfig.ExposeHeaders = []string{middleware.RequestIDHeader, "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
+	r.Use(cors.New(corsConfig))
 
 	{
+		// Kept for existing monitors, it checks nothing, like /livez
 		r.GET("/health", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
 				"status":   "active",
@@ -70,36 +215,63 @@ func main() {
 				"message":  "Systems Nominal. Ready to Burn.",
 			})
 		})
-		r.POST("/auth/register", authHandler.Register)
-		r.POST("/auth/login", authHandler.Login)
-		r.GET("/snippets/:id", snippetHandler.Get)
-		r.GET("/snippets/:id/meta", snippetHandler.GetMeta)
+		// Probes: livez restarts a hung process, readyz takes an instance out of rotation
+		r.GET("/livez", healthHandler.Live)
+		r.GET("/readyz", healthHandler.Ready)
+		// Prometheus scrape endpoint, behind a bearer token when METRICS_TOKEN is set
+		r.GET("/metrics", middleware.MetricsToken(cfg.Metrics.Token), gin.WrapH(metrics.Handler()))
+		r.POST("/auth/register", authLimit, authHandler.Register)
+		r.POST("/auth/login", authLimit, authHandler.Login)
+		r.POST("/auth/refresh", publicLimit, authHandler.Refresh)
+		r.POST("/auth/mfa/verify", authLimit, authHandler.VerifyMFA)
+		r.POST("/auth/verify-email", authLimit, authHandler.VerifyEmail)
+		r.POST("/auth/forgot-password", mailLimit, authHandler.ForgotPassword)
+		r.POST("/auth/reset-password", authLimit, authHandler.ResetPassword)
+		r.GET("/snippets/:id", publicLimit, snippetHandler.Get)
+		r.POST("/snippets/:id/reveal", publicLimit, middleware.OptionalAuthMiddleware(authService), snippetHandler.Reveal)
+		r.GET("/snippets/:id/meta", publicLimit, snippetHandler.GetMeta)
+		r.GET("/links/:id", publicLimit, snippetHandler.GetLink)
+		r.POST("/links/:id/reveal", publicLimit, middleware.OptionalAuthMiddleware(authService), snippetHandler.RevealLink)
 	}
 
-	// Protected Routes
+	// Protected Routes (session JWTs or personal API tokens with the route's scope)
 	protected := r.Group("")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(authService, apiTokenService), accountLimit)
 	{
 		protected.GET("/me", authHandler.GetMe)
-		protected.GET("/dashboard", snippetHandler.GetDashboard)
-		protected.POST("/snippets", snippetHandler.Create)
-		protected.GET("/snippets", snippetHandler.List)
-		protected.DELETE("/snippets/:id", snippetHandler.Delete)
+		protected.POST("/auth/logout", middleware.RequireSession(), authHandler.Logout)
+		protected.POST("/auth/verify-email/resend", middleware.RequireSession(), mailLimit, authHandler.ResendVerification)
+		protected.POST("/auth/change-password", middleware.RequireSession(), authHandler.ChangePassword)
+		protected.POST("/auth/mfa/setup", middleware.RequireSession(), authHandler.SetupMFA)
+		protected.POST("/auth/mfa/enable", middleware.RequireSession(), authHandler.EnableMFA)
+		protected.POST("/auth/mfa/disable", middleware.RequireSession(), authHandler.DisableMFA)
+		protected.POST("/auth/mfa/recovery-codes", middleware.RequireSession(), authHandler.RegenerateRecoveryCodes)
+		protected.GET("/dashboard", middleware.RequireScope(models.ScopeDashboardRead), snippetHandler.GetDashboard)
+		protected.POST("/snippets", middleware.RequireScope(models.ScopeSnippetsCreate), snippetHandler.Create)
+		protected.POST("/snippets/files", middleware.RequireScope(models.ScopeSnippetsCreate), snippetHandler.CreateFile)
+		protected.GET("/snippets", middleware.RequireScope(models.ScopeSnippetsReadOwn), snippetHandler.List)
+		protected.GET("/snippets/history", middleware.RequireScope(models.ScopeSnippetsReadOwn), snippetHandler.History)
+		protected.DELETE("/snippets/:id", middleware.RequireScope(models.ScopeSnippetsDelete), snippetHandler.Delete)
+		protected.POST("/snippets/:id/links", middleware.RequireScope(models.ScopeLinksManage), snippetHandler.CreateLink)
+		protected.GET("/snippets/:id/links", middleware.RequireScope(models.ScopeLinksManage), snippetHandler.ListLinks)
+		protected.DELETE("/snippets/:id/links/:linkID", middleware.RequireScope(models.ScopeLinksManage), snippetHandler.RevokeLink)
+		protected.POST("/webhooks", middleware.RequireScope(models.ScopeWebhooksManage), webhookHandler.Create)
+		protected.GET("/webhooks", middleware.RequireScope(models.ScopeWebhooksManage), webhookHandler.List)
+		protected.DELETE("/webhooks/:id", middleware.RequireScope(models.ScopeWebhooksManage), webhookHandler.Delete)
+		protected.GET("/webhooks/:id/deliveries", middleware.RequireScope(models.ScopeWebhooksManage), webhookHandler.Deliveries)
+		protected.POST("/tokens", middleware.RequireSession(), apiTokenHandler.Create)
+		protected.GET("/tokens", middleware.RequireSession(), apiTokenHandler.List)
+		protected.DELETE("/tokens/:id", middleware.RequireSession(), apiTokenHandler.Revoke)
 	}
 
-	// Get port from env or default to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Println("Listening on port: " + port)
+	slog.Info("Listening", "port", cfg.Server.Port)
 
 	// if err := r.Run(":" + port); err != nil {
 	// 	log.Fatal("Server Failed to start: ", err)
 	// }
 
 	server := &http.Server{
-		Addr:    ":" + port,
+		Addr:    ":" + cfg.Server.Port,
 		Handler: r,
 	}
 
@@ -107,7 +279,7 @@ func main() {
 	go func() {
 		// server.ListenAndServe runs forever (infinite loop) until shutdown
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server Failed to start: %v", err)
+			logging.Fatal("Server failed to start", "error", err)
 		}
 	}()
 
@@ -118,24 +290,52 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	// Block main goroutine here until a signal is received from the channel
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
+
+	// Fail readiness first and keep serving while load balancers notice,
+	// so no new request lands on a server that is about to stop accepting
+	healthService.Drain()
+	slog.Info("Draining", "delay", cfg.Server.DrainDelay)
+	time.Sleep(cfg.Server.DrainDelay)
 
-	// Create a context that automatically expires after 10 seconds (sets a deadline)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Create a context that automatically expires after SHUTDOWN_TIMEOUT (sets a deadline)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	// Ensures cancel() is called when main() exits to release context resources
 	defer cancel()
 
 	// Graceful shutdown: stops accepting new requests, waits for active requests
-	// to finish (up to 10 seconds), then closes all connections
+	// to finish (up to SHUTDOWN_TIMEOUT), then closes all connections
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal("Server Shutdown Failed:", err)
+		logging.Fatal("Server Shutdown Failed", "error", err)
+	}
+
+	// Let the background tasks finish their current statement before the database goes away
+	stopTasks()
+	for _, task := range []struct {
+		name string
+		done <-chan struct{}
+	}{
+		{"janitor", janitorDone},
+		{"key rotation", rekeyDone},
+		{"webhook dispatcher", webhooksDone},
+	} {
+		select {
+		case <-task.done:
+		case <-ctx.Done():
+			slog.Warn("Background task did not stop in time", "task", task.name)
+		}
+	}
+
+	// Send the spans still buffered
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
 
 	// Close the database connection
 	if err := sqlDB.Close(); err != nil {
-		log.Fatal("Failed to close database connection: ", err)
+		logging.Fatal("Failed to close database connection", "error", err)
 	}
 
-	log.Println("Server exited successfully")
+	slog.Info("Server exited successfully")
 
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+
+	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/logging"
+	"github.com/direwen/flashpaper/internal/migrations"
+)
+
+const migrateUsage = "usage: main migrate status | up | down [n]"
+
+func migrateUsageExit() {
+	fmt.Fprintln(os.Stderr, migrateUsage)
+	os.Exit(2)
+}
+
+// runMigrate is the "migrate" subcommand: status lists the migrations, up applies
+// the pending ones and down rolls back the latest n (default 1)
+func runMigrate(cfg config.Database, args []string) {
+	if len(args) == 0 {
+		migrateUsageExit()
+	}
+
+	// Only the database settings matter here, a migration job needs no other secrets
+	if err := cfg.Validate(); err != nil {
+		logging.Fatal("Invalid database configuration", "error", err)
+	}
+
+	config.ConnectDB(cfg)
+	migrator, err := migrations.New(config.GetDB())
+	if err != nil {
+		logging.Fatal("Failed to load migrations", "error", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logging.Fatal("Failed to read migration status", "error", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			logging.Fatal("Failed to apply migrations", "error", err)
+		}
+		slog.Info("Applied migrations", "count", count)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				migrateUsageExit()
+			}
+		}
+
+		count, err := migrator.Down(ctx, steps)
+		if err != nil {
+			logging.Fatal("Failed to roll back migrations", "error", err)
+		}
+		slog.Info("Rolled back migrations", "count", count)
+
+	default:
+		migrateUsageExit()
+	}
+}
+
+// migrateOnBoot applies pending migrations before the server starts. Instances
+// booting together wait on the migration lock, only the first one does the work.
+func migrateOnBoot(db *gorm.DB) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		logging.Fatal("Failed to load migrations", "error", err)
+	}
+
+	slog.Info("Running migrations")
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		logging.Fatal("Failed to migrate database", "error", err)
+	}
+	slog.Info("Migrations ran successfully", "applied", count)
+}
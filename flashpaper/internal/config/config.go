@@ -0,0 +1,371 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting of the server. Each field is named three ways: an
+// environment variable (env), a key in the config file (section.key) and a flag
+// (--section-key). Later sources win: defaults, file, environment, flags.
+type Config struct {
+	Server    Server    `key:"server"`
+	Database  Database  `key:"database"`
+	Security  Security  `key:"security"`
+	Tokens    Tokens    `key:"tokens"`
+	Tasks     Tasks     `key:"tasks"`
+	Webhooks  Webhooks  `key:"webhooks"`
+	Storage   Storage   `key:"storage"`
+	Mail      Mail      `key:"mail"`
+	RateLimit RateLimit `key:"rate_limit"`
+	Log       Log       `key:"log"`
+	Metrics   Metrics   `key:"metrics"`
+	Tracing   Tracing   `key:"tracing"`
+}
+
+type Server struct {
+	Port      string `key:"port" env:"PORT" default:"8080"`
+	ClientURL string `key:"client_url" env:"CLIENT_URL" default:"http://localhost:3000"`
+	// CORSOrigins defaults to ClientURL alone
+	CORSOrigins []string `key:"cors_origins" env:"CORS_ORIGINS"`
+	// TrustedProxies may set X-Forwarded-For, IPs or CIDRs
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	MaxUploadSize  int64    `key:"max_upload_size" env:"MAX_UPLOAD_SIZE" default:"26214400"`
+	// DrainDelay is how long /readyz reports not ready before shutdown starts,
+	// enough for load balancers to notice; ShutdownTimeout then bounds in-flight requests
+	DrainDelay      time.Duration `key:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"10s"`
+	// ReadinessTimeout bounds the database ping of /readyz
+	ReadinessTimeout time.Duration `key:"readiness_timeout" env:"READINESS_TIMEOUT" default:"2s"`
+}
+
+type Database struct {
+	Driver string `key:"driver" env:"DB_DRIVER" default:"postgres"`
+	// URL replaces the separate Postgres settings below
+	URL           string `key:"url" env:"DB_URL" secret:"true"`
+	Host          string `key:"host" env:"DB_HOST"`
+	Port          string `key:"port" env:"DB_PORT" default:"5432"`
+	User          string `key:"user" env:"DB_USER"`
+	Password      string `key:"password" env:"DB_PASSWORD" secret:"true"`
+	Name          string `key:"name" env:"DB_NAME"`
+	SSLMode       string `key:"sslmode" env:"DB_SSLMODE"`
+	Path          string `key:"path" env:"DB_PATH" default:"./data/flashpaper.db"`
+	MigrateOnBoot bool   `key:"migrate_on_boot" env:"MIGRATE_ON_BOOT" default:"true"`
+}
+
+type Security struct {
+	JWTSecret     string `key:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	EncryptionKey string `key:"encryption_key" env:"ENCRYPTION_KEY" secret:"true"`
+	// EncryptionKeys is a comma separated list of id:key pairs, it replaces EncryptionKey
+	EncryptionKeys      string `key:"encryption_keys" env:"ENCRYPTION_KEYS" secret:"true"`
+	EncryptionActiveKey string `key:"encryption_active_key" env:"ENCRYPTION_ACTIVE_KEY"`
+}
+
+type Tokens struct {
+	Access            time.Duration `key:"access" env:"TOKEN_EXPIRATION" default:"15m"`
+	Refresh           time.Duration `key:"refresh" env:"REFRESH_TOKEN_EXPIRATION" default:"720h"`
+	MFA               time.Duration `key:"mfa" env:"MFA_TOKEN_EXPIRATION" default:"5m"`
+	Reveal            time.Duration `key:"reveal" env:"REVEAL_TOKEN_EXPIRATION" default:"2m"`
+	EmailVerification time.Duration `key:"email_verification" env:"EMAIL_VERIFICATION_EXPIRATION" default:"48h"`
+	PasswordReset     time.Duration `key:"password_reset" env:"PASSWORD_RESET_EXPIRATION" default:"1h"`
+}
+
+type Tasks struct {
+	JanitorInterval time.Duration `key:"janitor_interval" env:"JANITOR_INTERVAL" default:"10s"`
+	// JanitorBatchSize bounds the rows one janitor statement deletes
+	JanitorBatchSize int `key:"janitor_batch_size" env:"JANITOR_BATCH_SIZE" default:"500"`
+	// JanitorBurntGrace keeps burnt snippets this long before purging them, zero purges on the next run
+	JanitorBurntGrace time.Duration `key:"janitor_burnt_grace" env:"JANITOR_BURNT_GRACE" default:"0s"`
+	// RetiredBlobGrace keeps blobs replaced by key rotation this long, longer than any download takes
+	RetiredBlobGrace time.Duration `key:"retired_blob_grace" env:"RETIRED_BLOB_GRACE" default:"1h"`
+	// HistoryRetention is how long the tombstones of gone snippets stay in the owner's history
+	HistoryRetention time.Duration `key:"history_retention" env:"HISTORY_RETENTION" default:"2160h"`
+	RekeyInterval    time.Duration `key:"rekey_interval" env:"REKEY_INTERVAL" default:"1m"`
+	RekeyBatchSize   int           `key:"rekey_batch_size" env:"REKEY_BATCH_SIZE" default:"100"`
+}
+
+type Webhooks struct {
+	Interval    time.Duration `key:"interval" env:"WEBHOOK_INTERVAL" default:"5s"`
+	MaxAttempts int           `key:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	// AllowInsecure permits http:// and private targets, for local development only
+	AllowInsecure bool `key:"allow_insecure" env:"WEBHOOK_ALLOW_INSECURE" default:"false"`
+}
+
+type Storage struct {
+	BlobPath string `key:"blob_path" env:"BLOB_STORAGE_PATH" default:"./data/blobs"`
+}
+
+type Mail struct {
+	Mailer       string `key:"mailer" env:"MAILER" default:"log"`
+	Dir          string `key:"dir" env:"MAIL_DIR"`
+	SMTPHost     string `key:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     string `key:"smtp_port" env:"SMTP_PORT" default:"587"`
+	SMTPUsername string `key:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `key:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	From         string `key:"from" env:"MAIL_FROM"`
+}
+
+type RateLimit struct {
+	// RedisURL shares the counters between instances, in memory when empty
+	RedisURL string `key:"redis_url" env:"RATE_LIMIT_REDIS_URL" secret:"true"`
+}
+
+type Log struct {
+	Level  string `key:"level" env:"LOG_LEVEL" default:"info"`
+	Format string `key:"format" env:"LOG_FORMAT" default:"text"`
+}
+
+type Metrics struct {
+	// Token guards /metrics when set
+	Token string `key:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+type Tracing struct {
+	Exporter string `key:"exporter" env:"TRACING_EXPORTER"`
+}
+
+// FileEnv names the config file when the -config flag is not given
+const FileEnv = "CONFIG_FILE"
+
+// setting is one field of Config with its names
+type setting struct {
+	key    string // section.key in the config file
+	env    string
+	flag   string
+	def    string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists every field of c, in declaration order
+func (c *Config) settings() []setting {
+	var all []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("key")
+		fields := sections.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			tag := fields.Type().Field(j).Tag
+			key := section + "." + tag.Get("key")
+			all = append(all, setting{
+				key:    key,
+				env:    tag.Get("env"),
+				flag:   strings.NewReplacer(".", "-", "_", "-").Replace(key),
+				def:    tag.Get("default"),
+				secret: tag.Get("secret") == "true",
+				value:  fields.Field(j),
+			})
+		}
+	}
+	return all
+}
+
+// Load reads the configuration from defaults, the config file, the environment
+// and the flags in args. It returns the arguments left after the flags, e.g. a
+// subcommand. Values that can't be parsed are reported together; whether the
+// settings make sense is up to Validate.
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("flashpaper", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(FileEnv), "YAML or TOML config file")
+	flagged := map[string]string{}
+	for _, s := range settings {
+		flags.Func(s.flag, "overrides "+s.env, func(raw string) error {
+			flagged[s.key] = raw
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var problems []error
+	for _, s := range settings {
+		if err := set(s.value, s.def); err != nil {
+			problems = append(problems, fmt.Errorf("default of %s: %w", s.env, err))
+		}
+	}
+
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return nil, nil, err
+		}
+		known := map[string]bool{}
+		for _, s := range settings {
+			known[s.key] = true
+			if raw, ok := values[s.key]; ok {
+				if err := set(s.value, raw); err != nil {
+					problems = append(problems, fmt.Errorf("%s in %s: %w", s.key, *file, err))
+				}
+			}
+		}
+		for _, key := range sortedKeys(values) {
+			if !known[key] {
+				problems = append(problems, fmt.Errorf("unknown setting %s in %s", key, *file))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		raw, ok, err := lookupEnv(s)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if ok {
+			if err := set(s.value, raw); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if raw, ok := flagged[s.key]; ok {
+			if err := set(s.value, raw); err != nil {
+				problems = append(problems, fmt.Errorf("--%s: %w", s.flag, err))
+			}
+		}
+	}
+
+	if len(cfg.Server.CORSOrigins) == 0 {
+		cfg.Server.CORSOrigins = []string{cfg.Server.ClientURL}
+	}
+
+	return cfg, flags.Args(), errors.Join(problems...)
+}
+
+// lookupEnv reads the variable of s. Secrets can also come from the file named by
+// <VAR>_FILE, as Docker and Kubernetes mount them.
+func lookupEnv(s setting) (string, bool, error) {
+	raw, ok := os.LookupEnv(s.env)
+	ok = ok && raw != ""
+
+	if !s.secret {
+		return raw, ok, nil
+	}
+
+	path := os.Getenv(s.env + "_FILE")
+	if path == "" {
+		return raw, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("%s and %s_FILE are both set", s.env, s.env)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", s.env, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+// set parses raw into the field v
+func set(v reflect.Value, raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetInt(n)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use e.g. 30s, 15m or 2h", raw)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// readFile reads a YAML or TOML config file into section.key => raw value
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &tree)
+	case ".toml":
+		err = toml.Unmarshal(content, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", tree, values)
+	return values, nil
+}
+
+// flatten turns nested sections into dotted keys, lists become comma separated
+func flatten(prefix string, tree map[string]any, values map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, values)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,52 +1,101 @@
 package config
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 
-	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/logging"
+	"github.com/direwen/flashpaper/internal/tracing"
 )
 
 // Define & Export Global DB instance
 // Use Pointer to share memory and prevent duplicating when called every time
 var DB *gorm.DB
 
-func ConnectDB() {
+// ConnectDB opens the database of cfg, which Validate has accepted
+func ConnectDB(cfg Database) {
 	var err error
-	var dsn string
-
-	if os.Getenv("DB_URL") != "" {
-		dsn = os.Getenv("DB_URL")
-	} else {
-		host := os.Getenv("DB_HOST")
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		dbname := os.Getenv("DB_NAME")
-		port := os.Getenv("DB_PORT")
-		sslmode := os.Getenv("DB_SSLMODE")
-
-		dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s", host, user, password, dbname, port, sslmode)
+	var dialector gorm.Dialector
+
+	switch cfg.Driver {
+	case "postgres":
+		dialector = postgres.Open(cfg.DSN())
+	case "sqlite":
+		dialector, err = sqliteDialector(cfg.Path)
+		if err != nil {
+			logging.Fatal("Failed to prepare sqlite database", "error", err)
+		}
+	default:
+		logging.Fatal("Unsupported DB_DRIVER", "driver", cfg.Driver)
 	}
 
-	//Connect to DB
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	//Connect to DB. Failed and slow queries are logged without their values,
+	//those hold snippet content and password hashes.
+	DB, err = gorm.Open(dialector, &gorm.Config{
+		Logger: logger.NewSlogLogger(slog.Default(), logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	})
 	if err != nil {
-		log.Fatal("Failed to connect to database: ", err)
+		logging.Fatal("Failed to connect to database", "error", err)
 	}
 
-	//Migrate models
-	log.Println("Running Migrations")
-	err = DB.AutoMigrate(&models.User{}, &models.Snippet{})
-	if err != nil {
-		log.Fatal("Failed to migrate models: ", err)
+	// A span per query, a no-op unless tracing is set up
+	if err := DB.Use(tracing.GormPlugin{}); err != nil {
+		logging.Fatal("Failed to register database tracing", "error", err)
+	}
+
+	// The schema is managed by the versioned scripts of internal/migrations
+	slog.Info("Connected to database successfully")
+}
+
+// DSN is DB_URL, or else a keyword/value DSN built from the separate settings
+func (d Database) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+
+	var parts []string
+	for _, part := range []struct{ keyword, value string }{
+		{"host", d.Host},
+		{"port", d.Port},
+		{"user", d.User},
+		{"password", d.Password},
+		{"dbname", d.Name},
+		{"sslmode", d.SSLMode},
+	} {
+		if part.value != "" {
+			parts = append(parts, part.keyword+"="+quoteDSNValue(part.value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDSNValue quotes a value so spaces and quotes in a password survive
+func quoteDSNValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// sqliteDialector opens the single file database at path. Transactions take
+// the write lock up front and wait for each other instead of failing as busy.
+func sqliteDialector(path string) (gorm.Dialector, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
 	}
-	log.Println("Migrations ran successfully")
 
-	log.Println("Connected to database successfully")
+	dsn := path + "?_txlock=immediate&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	return sqlite.Open(dsn), nil
 }
 
 func GetDB() *gorm.DB {
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/direwen/flashpaper/internal/logging"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// MinJWTSecretLength is the shortest HMAC secret accepted, 256 bits for HS256
+const MinJWTSecretLength = 32
+
+// Validate reports every setting that would make the server fail or misbehave,
+// all at once, so a deploy doesn't have to be retried once per mistake
+func (c *Config) Validate() error {
+	return errors.Join(
+		c.Server.validate(),
+		c.Database.Validate(),
+		c.Security.validate(),
+		c.Tokens.validate(),
+		c.Tasks.validate(),
+		c.Webhooks.validate(),
+		c.Storage.validate(),
+		c.Mail.validate(),
+		c.RateLimit.validate(),
+		c.Log.validate(),
+		c.Tracing.validate(),
+	)
+}
+
+func (s Server) validate() error {
+	var problems []error
+
+	if port, err := strconv.Atoi(s.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Errorf("PORT must be a port number, got %q", s.Port))
+	}
+	if err := checkURL(s.ClientURL); err != nil {
+		problems = append(problems, fmt.Errorf("CLIENT_URL %w", err))
+	}
+	for _, origin := range s.CORSOrigins {
+		if err := checkURL(origin); err != nil {
+			problems = append(problems, fmt.Errorf("CORS_ORIGINS entry %w", err))
+		}
+	}
+	for _, proxy := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP or CIDR", proxy))
+		}
+	}
+	if s.MaxUploadSize < 1 {
+		problems = append(problems, errors.New("MAX_UPLOAD_SIZE must be positive"))
+	}
+	if s.DrainDelay < 0 {
+		problems = append(problems, errors.New("SHUTDOWN_DRAIN_DELAY must not be negative"))
+	}
+	problems = append(problems, positive("SHUTDOWN_TIMEOUT", s.ShutdownTimeout), positive("READINESS_TIMEOUT", s.ReadinessTimeout))
+
+	return errors.Join(problems...)
+}
+
+// Validate checks the database settings on their own, the migrate subcommand needs no others
+func (d Database) Validate() error {
+	switch d.Driver {
+	case "postgres":
+		if d.URL == "" && d.Host == "" {
+			return errors.New("set DB_URL or DB_HOST for the postgres driver")
+		}
+		// Parses the DSN like the driver will, without connecting
+		if _, err := pgconn.ParseConfig(d.DSN()); err != nil {
+			return fmt.Errorf("invalid postgres connection settings: %w", err)
+		}
+	case "sqlite":
+		if d.Path == "" {
+			return errors.New("DB_PATH is required for the sqlite driver")
+		}
+	default:
+		return fmt.Errorf("DB_DRIVER must be postgres or sqlite, got %q", d.Driver)
+	}
+	return nil
+}
+
+func (s Security) validate() error {
+	var problems []error
+
+	if len(s.JWTSecret) < MinJWTSecretLength {
+		problems = append(problems, fmt.Errorf("JWT_SECRET must be at least %d bytes", MinJWTSecretLength))
+	}
+	// Key lengths, key ids and the active key, checked like at boot
+	if _, err := utils.ParseKeyring(s.EncryptionKeys, s.EncryptionActiveKey, s.EncryptionKey); err != nil {
+		problems = append(problems, fmt.Errorf("encryption keys: %w", err))
+	}
+
+	return errors.Join(problems...)
+}
+
+func (t Tokens) validate() error {
+	return errors.Join(
+		positive("TOKEN_EXPIRATION", t.Access),
+		positive("REFRESH_TOKEN_EXPIRATION", t.Refresh),
+		positive("MFA_TOKEN_EXPIRATION", t.MFA),
+		positive("REVEAL_TOKEN_EXPIRATION", t.Reveal),
+		positive("EMAIL_VERIFICATION_EXPIRATION", t.EmailVerification),
+		positive("PASSWORD_RESET_EXPIRATION", t.PasswordReset),
+	)
+}
+
+func (t Tasks) validate() error {
+	var problems []error
+	problems = append(problems,
+		positive("JANITOR_INTERVAL", t.JanitorInterval),
+		positive("HISTORY_RETENTION", t.HistoryRetention),
+		positive("RETIRED_BLOB_GRACE", t.RetiredBlobGrace),
+		positive("REKEY_INTERVAL", t.RekeyInterval),
+	)
+	if t.RekeyBatchSize < 1 {
+		problems = append(problems, errors.New("REKEY_BATCH_SIZE must be at least 1"))
+	}
+	if t.JanitorBatchSize < 1 {
+		problems = append(problems, errors.New("JANITOR_BATCH_SIZE must be at least 1"))
+	}
+	if t.JanitorBurntGrace < 0 {
+		problems = append(problems, errors.New("JANITOR_BURNT_GRACE must not be negative"))
+	}
+	return errors.Join(problems...)
+}
+
+func (w Webhooks) validate() error {
+	var problems []error
+	problems = append(problems, positive("WEBHOOK_INTERVAL", w.Interval))
+	if w.MaxAttempts < 1 {
+		problems = append(problems, errors.New("WEBHOOK_MAX_ATTEMPTS must be at least 1"))
+	}
+	return errors.Join(problems...)
+}
+
+func (s Storage) validate() error {
+	if s.BlobPath == "" {
+		return errors.New("BLOB_STORAGE_PATH is required")
+	}
+	return nil
+}
+
+func (m Mail) validate() error {
+	switch m.Mailer {
+	case "log":
+		return nil
+	case "smtp":
+		var problems []error
+		if m.SMTPHost == "" {
+			problems = append(problems, errors.New("SMTP_HOST is required for the smtp mailer"))
+		}
+		if port, err := strconv.Atoi(m.SMTPPort); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Errorf("SMTP_PORT must be a port number, got %q", m.SMTPPort))
+		}
+		if m.From == "" {
+			problems = append(problems, errors.New("MAIL_FROM is required for the smtp mailer"))
+		}
+		return errors.Join(problems...)
+	default:
+		return fmt.Errorf("MAILER must be log or smtp, got %q", m.Mailer)
+	}
+}
+
+func (r RateLimit) validate() error {
+	if r.RedisURL == "" {
+		return nil
+	}
+	if _, err := redis.ParseURL(r.RedisURL); err != nil {
+		// The URL may hold a password, only say what is wrong with it
+		return errors.New("RATE_LIMIT_REDIS_URL is not a valid redis:// URL")
+	}
+	return nil
+}
+
+func (l Log) validate() error {
+	_, err := logging.New(io.Discard, l.Level, l.Format)
+	return err
+}
+
+func (t Tracing) validate() error {
+	switch t.Exporter {
+	case "", "otlp", "stdout":
+		return nil
+	default:
+		return fmt.Errorf("TRACING_EXPORTER must be otlp or stdout, got %q", t.Exporter)
+	}
+}
+
+func positive(env string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive", env)
+	}
+	return nil
+}
+
+// checkURL accepts absolute http(s) URLs such as https://flashpaper.example
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return nil
+}
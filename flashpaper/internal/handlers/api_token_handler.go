@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type APITokenHandler struct {
+	service *services.APITokenService
+}
+
+func NewAPITokenHandler(service *services.APITokenService) *APITokenHandler {
+	return &APITokenHandler{
+		service: service,
+	}
+}
+
+type CreateAPITokenRequest struct {
+	Name      string   `json:"name" binding:"required,max=100"`
+	Scopes    []string `json:"scopes" binding:"required,min=1,dive,required"`
+	ExpiresIn int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"` // Omit for a token that never expires
+}
+
+func (h *APITokenHandler) Create(c *gin.Context) {
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	token, plain, err := h.service.CreateToken(c.Request.Context(), userID, services.CreateAPITokenInput{
+		Name:          req.Name,
+		Scopes:        req.Scopes,
+		ExpiresInDays: req.ExpiresIn,
+	})
+	if err != nil {
+		sendServiceError(c, err, "api token")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusCreated, gin.H{
+		"id":         token.ID,
+		"name":       token.Name,
+		"token":      plain, // Shown once
+		"scopes":     strings.Split(token.Scopes, ","),
+		"expires_at": token.ExpiresAt,
+		"created_at": token.CreatedAt,
+	})
+}
+
+func (h *APITokenHandler) List(c *gin.Context) {
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	tokens, err := h.service.ListTokens(c.Request.Context(), userID)
+	if err != nil {
+		sendServiceError(c, err, "api tokens")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, tokens)
+}
+
+func (h *APITokenHandler) Revoke(c *gin.Context) {
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := h.service.RevokeToken(c.Request.Context(), userID, tokenID); err != nil {
+		sendServiceError(c, err, "api token")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message": "API token revoked successfully",
+	})
+}
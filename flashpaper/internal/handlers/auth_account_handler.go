@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		sendServiceError(c, err, "verification")
+		return
+	}
+
+	utils.SendMessage(c, http.StatusOK, "Email verified successfully")
+}
+
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := h.service.ResendVerification(c.Request.Context(), userID); err != nil {
+		sendServiceError(c, err, "verification")
+		return
+	}
+
+	utils.SendMessage(c, http.StatusOK, "Verification email sent")
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Same answer whether or not the account exists
+	h.service.ForgotPassword(c.Request.Context(), req.Email)
+
+	utils.SendMessage(c, http.StatusOK, "If an account with this email exists, a reset link is on its way")
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		sendServiceError(c, err, "reset")
+		return
+	}
+
+	utils.SendMessage(c, http.StatusOK, "Password reset successfully, please log in")
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// ChangePassword signs out every other session and returns a new token pair for this one
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	tokens, err := h.service.ChangePassword(c.Request.Context(), userID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		// A wrong current password is a 400, the session itself is fine
+		sendServiceError(c, err, "user")
+		return
+	}
+
+	sendTokens(c, tokens)
+}
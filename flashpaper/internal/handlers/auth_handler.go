@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -37,7 +39,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	// Call the service
 	err := h.service.RegisterUser(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, err)
+		sendServiceError(c, err, "user")
 		return
 	}
 
@@ -60,14 +62,77 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Login
-	token, err := h.service.LoginUser(c.Request.Context(), req.Email, req.Password)
+	result, err := h.service.LoginUser(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
-		utils.SendError(c, http.StatusUnauthorized, err)
+		sendServiceError(c, err, "user")
 		return
 	}
 
+	// Two-factor accounts continue at /auth/mfa/verify
+	if result.Tokens == nil {
+		utils.SendSuccess(c, http.StatusOK, gin.H{
+			"mfa_required":         true,
+			"mfa_token":            result.MFAToken,
+			"mfa_token_expires_at": result.MFAExpiresAt,
+		})
+		return
+	}
+
+	sendTokens(c, result.Tokens)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh rotates a refresh token into a new access/refresh token pair
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := h.service.RefreshTokens(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		sendServiceError(c, err, "session")
+		return
+	}
+
+	sendTokens(c, tokens)
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	// All signs out every session of the user, not just this one
+	All bool `json:"all"`
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	// The body is optional
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	claimsVal, _ := c.Get("accessClaims")
+	claims := claimsVal.(*utils.AccessClaims)
+
+	if err := h.service.Logout(c.Request.Context(), claims, req.RefreshToken, req.All); err != nil {
+		sendServiceError(c, err, "session")
+		return
+	}
+
+	utils.SendMessage(c, http.StatusOK, "Logged out successfully")
+}
+
+func sendTokens(c *gin.Context, tokens *services.TokenPair) {
 	utils.SendSuccess(c, http.StatusOK, gin.H{
-		"token": token,
+		"token":                    tokens.AccessToken,
+		"token_expires_at":         tokens.AccessExpiresAt,
+		"refresh_token":            tokens.RefreshToken,
+		"refresh_token_expires_at": tokens.RefreshExpiresAt,
 	})
 }
 
@@ -83,7 +148,7 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 
 	user, err := h.service.GetUser(c.Request.Context(), userID)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, err)
+		sendServiceError(c, err, "user")
 		return
 	}
 
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type VerifyMFARequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// VerifyMFA is the second login step for two-factor accounts
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := h.service.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		sendServiceError(c, err, "mfa")
+		return
+	}
+
+	sendTokens(c, tokens)
+}
+
+// SetupMFA generates a TOTP secret to scan into an authenticator app
+func (h *AuthHandler) SetupMFA(c *gin.Context) {
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	secret, uri, err := h.service.SetupTOTP(c.Request.Context(), userID)
+	if err != nil {
+		sendServiceError(c, err, "mfa")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// EnableMFA confirms the setup with a first code and returns the recovery codes once
+func (h *AuthHandler) EnableMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	codes, err := h.service.EnableTOTP(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		sendServiceError(c, err, "mfa")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+type DisableMFARequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	var req DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := h.service.DisableTOTP(c.Request.Context(), userID, req.Password, req.Code, req.RecoveryCode); err != nil {
+		sendServiceError(c, err, "mfa")
+		return
+	}
+
+	utils.SendMessage(c, http.StatusOK, "Two-factor authentication disabled")
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		sendServiceError(c, err, "mfa")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"recovery_codes": codes,
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type errorMapping struct {
+	err    error
+	status int
+	// message is shown to people, %s is replaced by the subject of the request
+	message string
+}
+
+// The code of an error in the response is the text of its sentinel, e.g. "burnt"
+var errorMappings = []errorMapping{
+	{services.ErrNotFound, http.StatusNotFound, "%s not found"},
+	{services.ErrExpired, http.StatusGone, "%s expired"},
+	{services.ErrBurnt, http.StatusGone, "%s burnt"},
+	{services.ErrRevoked, http.StatusGone, "%s revoked"},
+
+	{services.ErrPassphraseRequired, http.StatusBadRequest, "passphrase required"},
+	{services.ErrWrongPassphrase, http.StatusForbidden, "wrong passphrase"},
+	{services.ErrLoginRequired, http.StatusUnauthorized, "sign in to reveal this snippet"},
+	{services.ErrForbidden, http.StatusForbidden, "you are not allowed to reveal this snippet"},
+	{services.ErrDecryptionFailed, http.StatusInternalServerError, "%s is unavailable"},
+
+	{services.ErrUserNotFound, http.StatusNotFound, "user not found"},
+	{services.ErrEmailTaken, http.StatusConflict, "user with this email already exists"},
+	{services.ErrInvalidCredentials, http.StatusUnauthorized, "invalid credentials"},
+	{services.ErrWrongPassword, http.StatusBadRequest, "wrong password"},
+	{services.ErrInvalidToken, http.StatusBadRequest, "invalid or expired %s link"},
+	{services.ErrAlreadyVerified, http.StatusConflict, "email is already verified"},
+	{services.ErrInvalidRefreshToken, http.StatusUnauthorized, "invalid or expired refresh token"},
+	{services.ErrRefreshTokenReused, http.StatusUnauthorized, "refresh token reuse detected, please log in again"},
+
+	{services.ErrInvalidMFAToken, http.StatusUnauthorized, "invalid or expired mfa token, please log in again"},
+	{services.ErrMFAAttemptsExceeded, http.StatusUnauthorized, "too many wrong codes, please log in again"},
+	{services.ErrInvalidMFACode, http.StatusBadRequest, "invalid code"},
+	{services.ErrMFACodeRequired, http.StatusBadRequest, "code or recovery code required"},
+	{services.ErrMFAAlreadyEnabled, http.StatusConflict, "two-factor authentication is already enabled"},
+	{services.ErrMFANotSetUp, http.StatusBadRequest, "start the setup first"},
+	{services.ErrMFANotEnabled, http.StatusBadRequest, "two-factor authentication is not enabled"},
+
+	{services.ErrTooManyAPITokens, http.StatusConflict, "too many api tokens"},
+	{services.ErrTooManyWebhooks, http.StatusConflict, "too many webhooks"},
+}
+
+// sendServiceError answers with the status, code and message of an error returned
+// by a service. subject names what the request was about ("snippet", "link", ...).
+// Anything unknown is a server failure, it is logged and not shown to the caller.
+func sendServiceError(c *gin.Context, err error, subject string) {
+	var locked *services.AccountLockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+		utils.SendErrorCode(c, http.StatusTooManyRequests, "account_locked", errors.New("too many failed logins, account temporarily locked"))
+		return
+	}
+
+	var invalid *services.ValidationError
+	if errors.As(err, &invalid) {
+		utils.SendErrorCode(c, http.StatusBadRequest, "invalid_input", invalid)
+		return
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.SendError(c, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	for _, mapping := range errorMappings {
+		if errors.Is(err, mapping.err) {
+			message := mapping.message
+			if strings.Contains(message, "%s") {
+				message = fmt.Sprintf(message, subject)
+			}
+			utils.SendErrorCode(c, mapping.status, mapping.err.Error(), errors.New(message))
+			return
+		}
+	}
+
+	slog.ErrorContext(c.Request.Context(), "Request failed", "method", c.Request.Method, "route", c.FullPath(), "error", err)
+	utils.SendErrorCode(c, http.StatusInternalServerError, "internal_error", errors.New("something went wrong, please try again"))
+}
@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
-	"math"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/direwen/flashpaper/internal/models"
 	"github.com/direwen/flashpaper/internal/services"
 	"github.com/direwen/flashpaper/pkg/utils"
 	"github.com/gin-gonic/gin"
@@ -14,18 +19,40 @@ import (
 
 type SnippetHandler struct {
 	service *services.SnippetService
+	// maxUploadSize caps the body of a file upload in bytes
+	maxUploadSize int64
 }
 
-func NewSnippetHandler(service *services.SnippetService) *SnippetHandler {
-	return &SnippetHandler{service: service}
+func NewSnippetHandler(service *services.SnippetService, maxUploadSize int64) *SnippetHandler {
+	return &SnippetHandler{service: service, maxUploadSize: maxUploadSize}
 }
 
 type CreateSnippetRequest struct {
-	Content   string `json:"content" binding:"required"`
-	Title     string `json:"title"`
-	Language  string `json:"language"`
-	MaxViews  int    `json:"max_views" binding:"required,min=1"`
-	ExpiresIn int    `json:"expires_in" binding:"required,min=1"`
+	Content      string              `json:"content" binding:"required_without_all=Envelope Files"`
+	Envelope     *EnvelopeRequest    `json:"envelope" binding:"omitempty"`
+	Files        []BundleFileRequest `json:"files" binding:"omitempty,max=20,dive"`
+	AllowedUsers []string            `json:"allowed_users" binding:"omitempty,max=50,dive,required"` // Emails or user IDs
+	Passphrase   string              `json:"passphrase" binding:"omitempty,min=4,max=256"`           // Optional second factor, sent out of band
+	MaxAttempts  int                 `json:"max_attempts" binding:"omitempty,min=1,max=10"`
+	Title        string              `json:"title"`
+	Language     string              `json:"language"`
+	MaxViews     int                 `json:"max_views" binding:"required,min=1"`
+	ExpiresIn    int                 `json:"expires_in" binding:"required,min=1"`
+}
+
+// BundleFileRequest is one file of a multi-file bundle snippet
+type BundleFileRequest struct {
+	Name     string `json:"name" binding:"required,max=255"`
+	Language string `json:"language"`
+	Content  string `json:"content" binding:"required"`
+}
+
+// EnvelopeRequest is a snippet already encrypted by the client (zero-knowledge mode)
+type EnvelopeRequest struct {
+	Algorithm  string              `json:"algorithm" binding:"required"`
+	Nonce      string              `json:"nonce" binding:"required,base64"`
+	Ciphertext string              `json:"ciphertext" binding:"required,base64"`
+	KDF        *services.KDFParams `json:"kdf"`
 }
 
 func (h *SnippetHandler) Create(c *gin.Context) {
@@ -39,17 +66,45 @@ func (h *SnippetHandler) Create(c *gin.Context) {
 	userIDVal, _ := c.Get("userID")
 	userID := userIDVal.(uuid.UUID)
 
-	snippet, err := h.service.CreateSnippet(
-		c.Request.Context(),
-		userID,
-		req.Content,
-		req.Title,
-		req.Language,
-		req.MaxViews,
-		req.ExpiresIn,
-	)
+	input := services.CreateSnippetInput{
+		Content:          req.Content,
+		Passphrase:       req.Passphrase,
+		MaxAttempts:      req.MaxAttempts,
+		AllowedUsers:     req.AllowedUsers,
+		Title:            req.Title,
+		Language:         req.Language,
+		MaxViews:         req.MaxViews,
+		ExpiresInMinutes: req.ExpiresIn,
+	}
+	if len(req.Files) > 0 {
+		if req.Content != "" {
+			utils.SendError(c, http.StatusBadRequest, errors.New("send either content or files, not both"))
+			return
+		}
+		for _, file := range req.Files {
+			input.Files = append(input.Files, services.BundleFile{
+				Name:     file.Name,
+				Language: file.Language,
+				Content:  file.Content,
+			})
+		}
+	}
+	if req.Envelope != nil {
+		if req.Content != "" {
+			utils.SendError(c, http.StatusBadRequest, errors.New("send either content or envelope, not both"))
+			return
+		}
+		input.Envelope = &services.Envelope{
+			Algorithm:  req.Envelope.Algorithm,
+			Nonce:      req.Envelope.Nonce,
+			Ciphertext: req.Envelope.Ciphertext,
+			KDF:        req.Envelope.KDF,
+		}
+	}
+
+	snippet, err := h.service.CreateSnippet(c.Request.Context(), userID, input)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, err)
+		sendServiceError(c, err, "snippet")
 		return
 	}
 
@@ -61,34 +116,268 @@ func (h *SnippetHandler) Create(c *gin.Context) {
 		"link":       fullLink,
 		"expires_at": snippet.ExpiresAt,
 		"max_views":  snippet.MaxViews,
+		"encryption": snippet.Encryption,
+		"kind":       snippet.Kind,
+		"passphrase": snippet.PassphraseProtected,
+		"restricted": snippet.Restricted,
 	})
 }
 
+// sendMultipartError answers a body that could not be read as multipart. That is
+// the client's fault, unless the body was simply too large.
+func sendMultipartError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.SendError(c, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	utils.SendError(c, http.StatusBadRequest, errors.New("malformed multipart body"))
+}
+
+// CreateFile accepts a multipart/form-data upload. The form fields (title, max_views,
+// expires_in) must come before the "file" part, which is streamed straight into the
+// encrypted blob store without being buffered.
+func (h *SnippetHandler) CreateFile(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, errors.New("multipart/form-data body is required"))
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			utils.SendError(c, http.StatusBadRequest, errors.New("file is required"))
+			return
+		}
+		if err != nil {
+			sendMultipartError(c, err)
+			return
+		}
+
+		if part.FormName() != "file" {
+			// Plain form fields are tiny, cap them anyway
+			value, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				sendMultipartError(c, err)
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		maxViews, err := strconv.Atoi(fields["max_views"])
+		if err != nil || maxViews < 1 {
+			utils.SendError(c, http.StatusBadRequest, errors.New("max_views must be sent before the file and be at least 1"))
+			return
+		}
+		expiresIn, err := strconv.Atoi(fields["expires_in"])
+		if err != nil || expiresIn < 1 {
+			utils.SendError(c, http.StatusBadRequest, errors.New("expires_in must be sent before the file and be at least 1"))
+			return
+		}
+
+		snippet, err := h.service.CreateFileSnippet(c.Request.Context(), userID, services.CreateFileSnippetInput{
+			FileName:         part.FileName(),
+			ContentType:      part.Header.Get("Content-Type"),
+			Body:             part,
+			Title:            fields["title"],
+			AllowedUsers:     splitList(fields["allowed_users"]),
+			MaxViews:         maxViews,
+			ExpiresInMinutes: expiresIn,
+		})
+		if err != nil {
+			sendServiceError(c, err, "snippet")
+			return
+		}
+
+		utils.SendSuccess(c, http.StatusCreated, gin.H{
+			"restricted": snippet.Restricted,
+			"message":    "Snippet created successfully",
+			"id":         snippet.ID,
+			"link":       "/snippets/" + snippet.ID.String(),
+			"expires_at": snippet.ExpiresAt,
+			"max_views":  snippet.MaxViews,
+			"kind":       snippet.Kind,
+			"file_size":  snippet.FileSize,
+		})
+		return
+	}
+}
+
+// splitList splits a comma separated form value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Get is the landing step of a reveal. It never consumes a view, it only returns
+// the snippet's metadata and a short-lived token required by Reveal.
 func (h *SnippetHandler) Get(c *gin.Context) {
-	// Get ID from route param
 	snippetID := c.Param("id")
+	uid, err := uuid.Parse(snippetID)
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "snippet")
+		return
+	}
 
-	snippet, err := h.service.GetSnippet(c.Request.Context(), snippetID)
+	metadata, err := h.service.GetSnippetMetadata(c.Request.Context(), snippetID)
 	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, errors.New("snippet's unavailable"))
+		sendServiceError(c, err, "snippet")
+		return
+	}
+
+	// Chat unfurlers get the landing page but no way to reveal
+	if userAgent := c.Request.UserAgent(); utils.IsLinkPreviewBot(userAgent) {
+		slog.InfoContext(c.Request.Context(), "Link preview bot fetched a snippet, no reveal token issued", "user_agent", userAgent)
+		utils.SendSuccess(c, http.StatusOK, gin.H{
+			"snippet": metadata,
+		})
+		return
+	}
+
+	revealToken, revealExpiresAt, err := utils.GenerateRevealToken(uid)
+	if err != nil {
+		sendServiceError(c, err, "snippet")
 		return
 	}
 
 	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"snippet":                 metadata,
+		"reveal_token":            revealToken,
+		"reveal_token_expires_at": revealExpiresAt,
+	})
+}
+
+type RevealSnippetRequest struct {
+	RevealToken string `json:"reveal_token" binding:"required"`
+	Passphrase  string `json:"passphrase"`
+}
+
+// Reveal consumes a view and returns the decrypted snippet. It requires the
+// reveal token from Get, so a single GET (e.g. a link preview) can never burn a link.
+func (h *SnippetHandler) Reveal(c *gin.Context) {
+	snippetID := c.Param("id")
+
+	// Never let a known preview bot consume a view
+	if userAgent := c.Request.UserAgent(); utils.IsLinkPreviewBot(userAgent) {
+		slog.InfoContext(c.Request.Context(), "Link preview bot tried to reveal a snippet, refused", "user_agent", userAgent)
+		utils.SendError(c, http.StatusForbidden, errors.New("link previews cannot reveal snippets"))
+		return
+	}
+
+	uid, err := uuid.Parse(snippetID)
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "snippet")
+		return
+	}
+
+	var req RevealSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateRevealToken(req.RevealToken, uid); err != nil {
+		utils.SendErrorCode(c, http.StatusForbidden, "invalid_reveal_token", err)
+		return
+	}
+
+	snippet, err := h.service.GetSnippet(c.Request.Context(), snippetID, revealRequest(c, req))
+	if err != nil {
+		sendServiceError(c, err, "snippet")
+		return
+	}
+
+	h.sendRevealed(c, snippet, snippet.MaxViews-snippet.CurrentViews)
+}
+
+// revealRequest describes the reader of a reveal (optional auth middleware sets userID)
+func revealRequest(c *gin.Context, req RevealSnippetRequest) services.RevealRequest {
+	reveal := services.RevealRequest{
+		Passphrase: req.Passphrase,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if userIDVal, exists := c.Get("userID"); exists {
+		reveal.UserID = userIDVal.(uuid.UUID)
+	}
+	return reveal
+}
+
+// sendRevealed writes a consumed snippet to the reader
+func (h *SnippetHandler) sendRevealed(c *gin.Context, snippet *models.Snippet, viewsLeft int) {
+	// Files are streamed back as a download instead of JSON
+	if snippet.Kind == models.KindFile {
+		h.sendFile(c, snippet, viewsLeft)
+		return
+	}
+
+	response := gin.H{
 		"title":      snippet.Title,
-		"content":    snippet.Content,
 		"language":   snippet.Language,
-		"views_left": snippet.MaxViews - snippet.CurrentViews,
+		"encryption": snippet.Encryption,
+		"views_left": viewsLeft,
 		"expires_at": snippet.ExpiresAt,
 		"created_at": snippet.CreatedAt,
-	})
+	}
+	switch {
+	// Client-side snippets return the envelope, the key is in the link fragment
+	case snippet.Encryption == models.EncryptionClient:
+		response["envelope"] = json.RawMessage(snippet.Content)
+	// Bundles return every file at once, they share the view that was just consumed
+	case snippet.Kind == models.KindBundle:
+		files, err := services.DecodeBundle(snippet)
+		if err != nil {
+			sendServiceError(c, err, "snippet")
+			return
+		}
+		response["files"] = files
+	default:
+		response["content"] = snippet.Content
+	}
+
+	utils.SendSuccess(c, http.StatusOK, response)
+}
+
+// sendFile streams the decrypted attachment of a revealed file snippet
+func (h *SnippetHandler) sendFile(c *gin.Context, snippet *models.Snippet, viewsLeft int) {
+	manifest, err := services.ReadFileManifest(snippet)
+	if err != nil {
+		sendServiceError(c, err, "snippet")
+		return
+	}
+
+	c.Header("Content-Type", manifest.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": manifest.Name}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Views-Left", strconv.Itoa(viewsLeft))
+	c.Status(http.StatusOK)
+
+	// Headers are already out, all we can do on failure is log and cut the response short
+	if err := h.service.StreamFile(c.Request.Context(), snippet, c.Writer); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to stream file snippet", "snippet_id", snippet.ID, "error", err)
+		c.Abort()
+	}
 }
 
 func (h *SnippetHandler) Delete(c *gin.Context) {
 	snippetIDval := c.Param("id")
 	snippetID, err := uuid.Parse(snippetIDval)
 	if err != nil {
-		utils.SendError(c, http.StatusBadRequest, errors.New("invalid id"))
+		sendServiceError(c, services.ErrNotFound, "snippet")
 		return
 	}
 
@@ -97,11 +386,7 @@ func (h *SnippetHandler) Delete(c *gin.Context) {
 
 	err = h.service.DeleteSnippet(c.Request.Context(), snippetID, userID)
 	if err != nil {
-		if err.Error() == "not_found" {
-			utils.SendError(c, http.StatusNotFound, errors.New("snippet not found or access denied"))
-		} else {
-			utils.SendError(c, http.StatusInternalServerError, err)
-		}
+		sendServiceError(c, err, "snippet")
 		return
 	}
 
@@ -121,7 +406,7 @@ func (h *SnippetHandler) GetDashboard(c *gin.Context) {
 
 	stats, err := h.service.GetDashboardStats(c.Request.Context(), userID)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, err)
+		sendServiceError(c, err, "dashboard")
 		return
 	}
 
@@ -137,20 +422,44 @@ func (h *SnippetHandler) List(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
-	snippets, total, err := h.service.GetActiveSnippets(c.Request.Context(), userID, page, limit)
+	snippets, pagination, err := h.service.GetActiveSnippets(c.Request.Context(), userID, page, limit)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, errors.New("failed to fetch snippets"))
+		sendServiceError(c, err, "snippets")
 		return
 	}
 
 	utils.SendSuccess(c, http.StatusOK, gin.H{
 		"data": snippets,
-		"meta": gin.H{
-			"current_page": page,
-			"per_page":     limit,
-			"total_items":  total,
-			"total_pages":  int(math.Ceil(float64(total) / float64(limit))),
-		},
+		"meta": pageMeta(pagination),
+	})
+}
+
+// pageMeta describes the page a listing returned, as the service clamped it
+func pageMeta(page services.Page) gin.H {
+	return gin.H{
+		"current_page": page.Number,
+		"per_page":     page.Limit,
+		"total_items":  page.Total,
+		"total_pages":  page.TotalPages(),
+	}
+}
+
+// History lists the owner's snippets that burnt, expired or were deleted
+func (h *SnippetHandler) History(c *gin.Context) {
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	history, pagination, err := h.service.GetSnippetHistory(c.Request.Context(), userID, page, limit)
+	if err != nil {
+		sendServiceError(c, err, "snippets")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"data": history,
+		"meta": pageMeta(pagination),
 	})
 }
 
@@ -159,14 +468,7 @@ func (h *SnippetHandler) GetMeta(c *gin.Context) {
 
 	snippetMetadata, err := h.service.GetSnippetMetadata(c.Request.Context(), snippetID)
 	if err != nil {
-		switch err.Error() {
-		case "not_found":
-			utils.SendError(c, http.StatusNotFound, errors.New("snippet not found"))
-		case "expired":
-			utils.SendError(c, http.StatusGone, errors.New("snippet expired"))
-		default:
-			utils.SendError(c, http.StatusInternalServerError, err)
-		}
+		sendServiceError(c, err, "snippet")
 		return
 	}
 
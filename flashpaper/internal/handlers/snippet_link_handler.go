@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type CreateLinkRequest struct {
+	Label     string `json:"label" binding:"required,max=100"`
+	MaxViews  int    `json:"max_views" binding:"required,min=1"`
+	ExpiresIn int    `json:"expires_in" binding:"omitempty,min=1"`
+}
+
+func (h *SnippetHandler) CreateLink(c *gin.Context) {
+	snippetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "snippet")
+		return
+	}
+
+	var req CreateLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	link, err := h.service.CreateLink(c.Request.Context(), userID, snippetID, services.CreateLinkInput{
+		Label:            req.Label,
+		MaxViews:         req.MaxViews,
+		ExpiresInMinutes: req.ExpiresIn,
+	})
+	if err != nil {
+		sendServiceError(c, err, "snippet")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusCreated, gin.H{
+		"id":         link.ID,
+		"label":      link.Label,
+		"link":       "/links/" + link.ID.String(),
+		"max_views":  link.MaxViews,
+		"expires_at": link.ExpiresAt,
+	})
+}
+
+func (h *SnippetHandler) ListLinks(c *gin.Context) {
+	snippetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "snippet")
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	links, err := h.service.ListLinks(c.Request.Context(), userID, snippetID)
+	if err != nil {
+		sendServiceError(c, err, "snippet")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, links)
+}
+
+func (h *SnippetHandler) RevokeLink(c *gin.Context) {
+	snippetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "snippet")
+		return
+	}
+	linkID, err := uuid.Parse(c.Param("linkID"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "link")
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := h.service.RevokeLink(c.Request.Context(), userID, snippetID, linkID); err != nil {
+		sendServiceError(c, err, "link")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message": "Link revoked successfully",
+	})
+}
+
+// GetLink is the non-consuming landing step of a recipient link, see Get
+func (h *SnippetHandler) GetLink(c *gin.Context) {
+	linkID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "link")
+		return
+	}
+
+	metadata, err := h.service.GetLinkMetadata(c.Request.Context(), linkID)
+	if err != nil {
+		sendServiceError(c, err, "link")
+		return
+	}
+
+	// Chat unfurlers get the landing page but no way to reveal
+	if userAgent := c.Request.UserAgent(); utils.IsLinkPreviewBot(userAgent) {
+		slog.InfoContext(c.Request.Context(), "Link preview bot fetched a link, no reveal token issued", "user_agent", userAgent)
+		utils.SendSuccess(c, http.StatusOK, gin.H{
+			"snippet": metadata,
+		})
+		return
+	}
+
+	revealToken, revealExpiresAt, err := utils.GenerateRevealToken(linkID)
+	if err != nil {
+		sendServiceError(c, err, "link")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"snippet":                 metadata,
+		"reveal_token":            revealToken,
+		"reveal_token_expires_at": revealExpiresAt,
+	})
+}
+
+// RevealLink consumes a view of a recipient link, see Reveal
+func (h *SnippetHandler) RevealLink(c *gin.Context) {
+	linkID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		sendServiceError(c, services.ErrNotFound, "link")
+		return
+	}
+
+	// Never let a known preview bot consume a view
+	userAgent := c.Request.UserAgent()
+	if utils.IsLinkPreviewBot(userAgent) {
+		slog.InfoContext(c.Request.Context(), "Link preview bot tried to reveal a link, refused", "user_agent", userAgent)
+		utils.SendError(c, http.StatusForbidden, errors.New("link previews cannot reveal snippets"))
+		return
+	}
+
+	var req RevealSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.ValidateRevealToken(req.RevealToken, linkID); err != nil {
+		utils.SendErrorCode(c, http.StatusForbidden, "invalid_reveal_token", err)
+		return
+	}
+
+	snippet, link, err := h.service.RevealLink(c.Request.Context(), linkID, revealRequest(c, req))
+	if err != nil {
+		sendServiceError(c, err, "link")
+		return
+	}
+
+	h.sendRevealed(c, snippet, link.MaxViews-link.CurrentViews)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+type WebhookHandler struct {
+	service *services.WebhookService
+}
+
+func NewWebhookHandler(service *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		service: service,
+	}
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,max=2048"`
+	Events []string `json:"events" binding:"required,min=1,dive,required"`
+}
+
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	webhook, secret, err := h.service.CreateWebhook(c.Request.Context(), userID, services.CreateWebhookInput{
+		URL:    req.URL,
+		Events: req.Events,
+	})
+	if err != nil {
+		sendServiceError(c, err, "webhook")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusCreated, gin.H{
+		"id":         webhook.ID,
+		"url":        webhook.URL,
+		"events":     strings.Split(webhook.Events, ","),
+		"secret":     secret, // Shown once, used to verify X-FlashPaper-Signature
+		"created_at": webhook.CreatedAt,
+	})
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	webhooks, err := h.service.ListWebhooks(c.Request.Context(), userID)
+	if err != nil {
+		sendServiceError(c, err, "webhooks")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	webhookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	if err := h.service.DeleteWebhook(c.Request.Context(), userID, webhookID); err != nil {
+		sendServiceError(c, err, "webhook")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// Deliveries is the delivery log of a webhook, newest first
+func (h *WebhookHandler) Deliveries(c *gin.Context) {
+	webhookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	userIDVal, _ := c.Get("userID")
+	userID := userIDVal.(uuid.UUID)
+
+	deliveries, err := h.service.ListDeliveries(c.Request.Context(), userID, webhookID, limit)
+	if err != nil {
+		sendServiceError(c, err, "webhook")
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, deliveries)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// Redacted replaces every value that must never reach the logs
+const Redacted = "[REDACTED]"
+
+// Attribute keys whose values are always redacted, whatever they hold. A key
+// matches when it contains one of these words, e.g. "new_password" or "mfa_token".
+var sensitiveKeyParts = []string{"password", "passphrase", "secret", "token", "authorization", "cookie", "ciphertext", "envelope"}
+
+// Keys matched exactly, as parts they would hide harmless keys like "status_code"
+var sensitiveKeys = map[string]bool{
+	"content":       true,
+	"body":          true,
+	"code":          true,
+	"recovery_code": true,
+	"key":           true,
+	"files":         true,
+}
+
+// Secrets that can slip into free text, e.g. a wrapped error or a URL
+var sensitivePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// JWTs: access, refresh and reveal tokens
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), Redacted},
+	// Personal API tokens
+	{regexp.MustCompile(regexp.QuoteMeta(utils.APITokenPrefix) + `[A-Za-z0-9_-]{8,}`), Redacted},
+	// Mailed and opaque tokens in links, the parameter name is kept
+	{regexp.MustCompile(`(?i)([?&](?:token|code|key)=)[^&\s"]+`), "${1}" + Redacted},
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveKeys[key] {
+		return true
+	}
+	// IDs name a row, e.g. "api_token_id", they are not the secret itself
+	if strings.HasSuffix(key, "_id") {
+		return false
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns attr with every secret it may hold replaced
+func redact(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, inner := range group {
+			redacted = append(redacted, redact(inner))
+		}
+		return slog.Group(attr.Key, redacted...)
+
+	case slog.KindString:
+		return slog.String(attr.Key, redactText(attr.Value.String()))
+
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactText(value.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, redactText(value.String()))
+		default:
+			// A value logged whole could be anything, a snippet or a request body
+			// included, so only what prints itself gets through
+			return slog.String(attr.Key, Redacted)
+		}
+	}
+
+	return attr
+}
+
+// redactText masks tokens that look like secrets inside free text
+func redactText(text string) string {
+	for _, sensitive := range sensitivePatterns {
+		text = sensitive.pattern.ReplaceAllString(text, sensitive.replacement)
+	}
+	return text
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer is for local development and tests: it writes each email as a file
+// when dir is set, otherwise it only logs recipient and subject.
+type LogMailer struct {
+	dir string
+}
+
+func NewLogMailer(dir string) (*LogMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &LogMailer{dir: dir}, nil
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	body := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Text)
+
+	if m.dir == "" {
+		// Mails hold sign-in and reset links, only say that one was sent
+		slog.InfoContext(ctx, "Mail not sent by the log mailer, set MAIL_DIR to keep messages", "to", msg.To, "subject", msg.Subject)
+		return nil
+	}
+
+	name := fmt.Sprintf("%d.txt", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(body), 0o600)
+}
@@ -0,0 +1,101 @@
+// Package metrics holds the Prometheus collectors of FlashPaper. They are served
+// on /metrics together with the Go runtime and process collectors. Labels only
+// ever carry fixed values (kinds, reasons, route templates), never IDs.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "flashpaper"
+
+var (
+	// SnippetsCreated counts stored snippets by kind (text, file, bundle)
+	SnippetsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snippets_created_total",
+		Help:      "Snippets created, by kind.",
+	}, []string{"kind"})
+
+	// SnippetViews counts consumed views, through the snippet itself or a recipient link
+	SnippetViews = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snippet_views_total",
+		Help:      "Views consumed by successful reveals, by snippet or link.",
+	}, []string{"via"})
+
+	// SnippetBurns counts snippets destroyed by their last view or last passphrase attempt
+	SnippetBurns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snippet_burns_total",
+		Help:      "Snippets burnt, by reason (max_views, passphrase_attempts).",
+	}, []string{"reason"})
+
+	// RevealFailures counts refused reveals by error code, e.g. expired or wrong_passphrase
+	RevealFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reveal_failures_total",
+		Help:      "Reveals that failed, by error code.",
+	}, []string{"reason"})
+
+	// DecryptionFailures counts stored secrets the keyring could not open. Anything
+	// above zero points at a missing or wrong key.
+	DecryptionFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decryption_failures_total",
+		Help:      "Stored secrets that failed to decrypt, by what was decrypted.",
+	}, []string{"secret"})
+
+	// Logins counts login attempts by step (password, mfa) and result
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by step (password, mfa) and result (success, mfa_required, failure, locked, error).",
+	}, []string{"step", "result"})
+
+	// JanitorRunDuration times each run of the janitor
+	JanitorRunDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "janitor_run_duration_seconds",
+		Help:      "Duration of janitor runs.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// JanitorRuns counts janitor runs by result (success, failure, standby when another instance holds the lock)
+	JanitorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "janitor_runs_total",
+		Help:      "Janitor runs, by result (success, failure, standby).",
+	}, []string{"result"})
+
+	// JanitorRowsDeleted counts rows the janitor removed, by table
+	JanitorRowsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "janitor_rows_deleted_total",
+		Help:      "Rows deleted by the janitor, by table.",
+	}, []string{"table"})
+
+	// HTTPRequestDuration times requests by route template, never by raw path
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method, route template and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// RegisterDB exports the connection pool stats of db (open, in use, idle, waits)
+func RegisterDB(db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Handler serves every registered collector in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -1,15 +1,30 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/direwen/flashpaper/pkg/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// TokenDenylist reports access tokens that were revoked before their expiry
+type TokenDenylist interface {
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// APITokenVerifier resolves personal API tokens to their user and scopes
+type APITokenVerifier interface {
+	VerifyAPIToken(ctx context.Context, token string) (uuid.UUID, []string, error)
+}
+
+// AuthMiddleware accepts both session JWTs and personal API tokens ("fp_...").
+// API token requests also get their scopes, which RequireScope checks.
+func AuthMiddleware(denylist TokenDenylist, apiTokens APITokenVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the auth header
 		authHeader := c.GetHeader("Authorization")
@@ -26,19 +41,98 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		// Validate Token
 		tokenString := parts[1]
-		userID, err := utils.ValidateToken(tokenString)
+
+		// Personal API token?
+		if strings.HasPrefix(tokenString, utils.APITokenPrefix) {
+			userID, scopes, err := apiTokens.VerifyAPIToken(c.Request.Context(), tokenString)
+			if err != nil {
+				// The code tells unknown, revoked and expired tokens apart
+				utils.SendErrorCode(c, http.StatusUnauthorized, err.Error(), errors.New("invalid, revoked or expired api token"))
+				c.Abort()
+				return
+			}
+
+			c.Set("userID", userID)
+			c.Set("scopes", scopes)
+			c.Next()
+			return
+		}
+
+		// Validate Token
+		claims, err := utils.ValidateToken(tokenString)
 		if err != nil {
 			utils.SendError(c, http.StatusUnauthorized, err)
 			c.Abort()
 			return
 		}
 
-		// Store User ID in Context
-		c.Set("userID", userID)
+		// Logged out or revoked?
+		revoked, err := denylist.IsTokenRevoked(c.Request.Context(), claims.TokenID)
+		if err != nil {
+			utils.SendError(c, http.StatusInternalServerError, errors.New("failed to verify token"))
+			c.Abort()
+			return
+		}
+		if revoked {
+			utils.SendError(c, http.StatusUnauthorized, errors.New("token has been revoked"))
+			c.Abort()
+			return
+		}
+
+		// Store User ID in Context (and the token itself, for logout)
+		c.Set("userID", claims.UserID)
+		c.Set("accessClaims", claims)
 
 		// Next Handler
 		c.Next()
 	}
 }
+
+// OptionalAuthMiddleware identifies the caller when a valid bearer token is sent,
+// but lets anonymous requests through. Public reveal routes use it so restricted
+// snippets can check who is asking.
+func OptionalAuthMiddleware(denylist TokenDenylist) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			// An invalid, expired or revoked token just means anonymous here
+			if claims, err := utils.ValidateToken(parts[1]); err == nil {
+				if revoked, err := denylist.IsTokenRevoked(c.Request.Context(), claims.TokenID); err == nil && !revoked {
+					c.Set("userID", claims.UserID)
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope lets API tokens through only when they were granted the scope.
+// Browser sessions have no scopes and always pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopesVal, isAPIToken := c.Get("scopes")
+		if isAPIToken && !slices.Contains(scopesVal.([]string), scope) {
+			utils.SendError(c, http.StatusForbidden, errors.New("api token lacks the "+scope+" scope"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects API tokens on routes that need a real login, like
+// managing the tokens themselves
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIToken := c.Get("scopes"); isAPIToken {
+			utils.SendError(c, http.StatusForbidden, errors.New("api tokens cannot be used here"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
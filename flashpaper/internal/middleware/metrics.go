@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/direwen/flashpaper/internal/metrics"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// Metrics times every request. Like the access log it labels by route template,
+// a label per snippet ID would both leak it and grow without bound.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsToken guards /metrics with a static bearer token, for scrapers reaching
+// the API over the same public address as everyone else. Empty leaves it open.
+func MetricsToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			utils.SendError(c, http.StatusUnauthorized, errors.New("invalid metrics token"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/ratelimit"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// RateLimitKey picks the bucket a request is counted against
+type RateLimitKey func(c *gin.Context) string
+
+// ByIP counts requests per client address
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByAccount counts requests per signed in user, anonymous requests per address.
+// It has to run after AuthMiddleware or OptionalAuthMiddleware.
+func ByAccount(c *gin.Context) string {
+	if userIDVal, ok := c.Get("userID"); ok {
+		return "user:" + userIDVal.(uuid.UUID).String()
+	}
+	return ByIP(c)
+}
+
+// RateLimit throttles a route group with a token bucket per key. name separates
+// the buckets of different groups. Responses carry the RateLimit-* headers of
+// the IETF draft, rejected ones also Retry-After.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateLimitKey) gin.HandlerFunc {
+	policy := fmt.Sprintf("%d;w=%d", limit.Burst, int(limit.Period.Seconds()))
+
+	return func(c *gin.Context) {
+		result, err := store.Allow(c.Request.Context(), name+":"+key(c), limit)
+		if err != nil {
+			// Fail open, an unreachable store must not take the API down with it
+			slog.ErrorContext(c.Request.Context(), "Rate limit store failed", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", seconds(result.ResetAfter))
+
+		if !result.Allowed {
+			c.Header("Retry-After", seconds(result.RetryAfter))
+			utils.SendError(c, http.StatusTooManyRequests, errors.New("too many requests, slow down"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// seconds rounds up, clients waiting the advertised time must not be rejected again
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
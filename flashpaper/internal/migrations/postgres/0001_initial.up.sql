@@ -0,0 +1,213 @@
+-- Baseline: the schema AutoMigrate used to create. IF NOT EXISTS lets databases
+-- created by AutoMigrate adopt it. Tables created by the first release lack the
+-- columns added since, the ALTERs below bring users and snippets up to date.
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid DEFAULT gen_random_uuid(),
+    email text NOT NULL,
+    password text NOT NULL,
+    email_verified_at timestamptz,
+    totp_secret text,
+    totp_enabled boolean DEFAULT false,
+    totp_last_step bigint,
+    failed_logins bigint NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_verified_at timestamptz,
+    ADD COLUMN IF NOT EXISTS totp_secret text,
+    ADD COLUMN IF NOT EXISTS totp_enabled boolean DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint,
+    ADD COLUMN IF NOT EXISTS failed_logins bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until timestamptz;
+
+CREATE TABLE IF NOT EXISTS snippets (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid,
+    content text NOT NULL,
+    encryption text NOT NULL DEFAULT 'server',
+    kind text NOT NULL DEFAULT 'text',
+    blob_key text,
+    file_size bigint,
+    title text,
+    language text,
+    current_views bigint DEFAULT 0,
+    max_views bigint DEFAULT 0,
+    passphrase_protected boolean DEFAULT false,
+    failed_attempts bigint DEFAULT 0,
+    max_attempts bigint DEFAULT 0,
+    restricted boolean DEFAULT false,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_snippets_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_snippets_expires_at ON snippets (expires_at);
+CREATE INDEX IF NOT EXISTS idx_snippets_user_id ON snippets (user_id);
+
+ALTER TABLE snippets
+    ADD COLUMN IF NOT EXISTS encryption text NOT NULL DEFAULT 'server',
+    ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT 'text',
+    ADD COLUMN IF NOT EXISTS blob_key text,
+    ADD COLUMN IF NOT EXISTS file_size bigint,
+    ADD COLUMN IF NOT EXISTS passphrase_protected boolean DEFAULT false,
+    ADD COLUMN IF NOT EXISTS failed_attempts bigint DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_attempts bigint DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS restricted boolean DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS snippet_links (
+    id uuid DEFAULT gen_random_uuid(),
+    snippet_id uuid NOT NULL,
+    label text NOT NULL,
+    current_views bigint DEFAULT 0,
+    max_views bigint DEFAULT 0,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_snippet_links_snippet FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_snippet_links_expires_at ON snippet_links (expires_at);
+CREATE INDEX IF NOT EXISTS idx_snippet_links_snippet_id ON snippet_links (snippet_id);
+
+CREATE TABLE IF NOT EXISTS snippet_link_views (
+    id uuid DEFAULT gen_random_uuid(),
+    link_id uuid NOT NULL,
+    ip text,
+    user_agent text,
+    viewed_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_snippet_links_views FOREIGN KEY (link_id) REFERENCES snippet_links (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_snippet_link_views_link_id ON snippet_link_views (link_id);
+
+CREATE TABLE IF NOT EXISTS snippet_allowed_users (
+    snippet_id uuid,
+    user_id uuid,
+    created_at timestamptz,
+    PRIMARY KEY (snippet_id, user_id),
+    CONSTRAINT fk_snippet_allowed_users_snippet FOREIGN KEY (snippet_id) REFERENCES snippets (id) ON DELETE CASCADE,
+    CONSTRAINT fk_snippet_allowed_users_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_snippet_allowed_users_user_id ON snippet_allowed_users (user_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_webhooks_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid DEFAULT gen_random_uuid(),
+    webhook_id uuid NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts bigint DEFAULT 0,
+    next_attempt_at timestamptz,
+    last_status_code bigint,
+    last_error text,
+    delivered_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_webhook_deliveries_webhook FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    family_id uuid NOT NULL,
+    token_hash text NOT NULL,
+    access_token_id text,
+    access_expires_at timestamptz,
+    expires_at timestamptz,
+    used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens (expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id text,
+    user_id uuid,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (token_id)
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    token_hash text NOT NULL,
+    scopes text NOT NULL,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_api_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    token_hash text NOT NULL,
+    attempts bigint DEFAULT 0,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_mfa_challenges_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mfa_challenges_token_hash ON mfa_challenges (token_hash);
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user_id ON mfa_challenges (user_id);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    purpose text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_expires_at ON user_tokens (expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tokens_token_hash ON user_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_snippets_burnt_at;
+ALTER TABLE snippets DROP COLUMN IF EXISTS burnt_at;
@@ -0,0 +1,8 @@
+-- Blobs replaced by key rotation, deleted by the janitor after RETIRED_BLOB_GRACE
+-- so downloads that already opened them can finish
+CREATE TABLE IF NOT EXISTS retired_blobs (
+    blob_key text NOT NULL,
+    retired_at timestamptz NOT NULL,
+    PRIMARY KEY (blob_key)
+);
+CREATE INDEX IF NOT EXISTS idx_retired_blobs_retired_at ON retired_blobs (retired_at);
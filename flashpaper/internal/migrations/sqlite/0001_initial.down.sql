@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS user_tokens;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS snippet_allowed_users;
+DROP TABLE IF EXISTS snippet_link_views;
+DROP TABLE IF EXISTS snippet_links;
+DROP TABLE IF EXISTS snippets;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,22 @@
+-- When a snippet ran out of views or passphrase attempts. The janitor purges
+-- burnt snippets after JANITOR_BURNT_GRACE instead of waiting for expires_at.
+ALTER TABLE snippets ADD COLUMN burnt_at datetime;
+CREATE INDEX IF NOT EXISTS idx_snippets_burnt_at ON snippets (burnt_at);
+
+-- Snippets burnt before this migration start their grace period now. A snippet
+-- whose own views ran out is only burnt once no recipient link can serve it,
+-- a passphrase burn stops every link. Times are written in the RFC 3339 form
+-- the driver stores them in so comparisons keep working.
+UPDATE snippets SET burnt_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+WHERE burnt_at IS NULL
+  AND current_views >= max_views
+  AND (
+    (passphrase_protected AND failed_attempts >= max_attempts)
+    OR NOT EXISTS (
+      SELECT 1 FROM snippet_links
+      WHERE snippet_links.snippet_id = snippets.id
+        AND snippet_links.revoked_at IS NULL
+        AND snippet_links.current_views < snippet_links.max_views
+        AND snippet_links.expires_at > strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+    )
+  );
@@ -0,0 +1 @@
+-- Wiped content is gone for good, there is nothing to restore
@@ -0,0 +1,15 @@
+-- Snippets whose own views ran out stay readable through links with views left,
+-- they only burn once those are used up too. Passphrase burns stop every link.
+UPDATE snippets SET burnt_at = NULL
+WHERE burnt_at IS NOT NULL
+  AND NOT (passphrase_protected AND failed_attempts >= max_attempts)
+  AND EXISTS (
+    SELECT 1 FROM snippet_links
+    WHERE snippet_links.snippet_id = snippets.id
+      AND snippet_links.revoked_at IS NULL
+      AND snippet_links.current_views < snippet_links.max_views
+      AND snippet_links.expires_at > strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+  );
+
+-- Burnt snippets keep their metadata only, like every snippet burnt from now on
+UPDATE snippets SET content = '' WHERE burnt_at IS NOT NULL;
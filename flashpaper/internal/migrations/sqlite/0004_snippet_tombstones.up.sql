@@ -0,0 +1,31 @@
+-- Content-free records of snippets that burnt, expired or were deleted, kept
+-- for HISTORY_RETENTION so owners can see what happened to them
+CREATE TABLE IF NOT EXISTS snippet_tombstones (
+    snippet_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    title text,
+    language text,
+    kind text NOT NULL DEFAULT 'text',
+    views integer DEFAULT 0,
+    max_views integer DEFAULT 0,
+    link_views integer DEFAULT 0,
+    reason text NOT NULL,
+    created_at datetime,
+    burnt_at datetime,
+    expired_at datetime,
+    ended_at datetime NOT NULL,
+    PRIMARY KEY (snippet_id),
+    CONSTRAINT fk_snippet_tombstones_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_snippet_tombstones_user_id ON snippet_tombstones (user_id);
+CREATE INDEX IF NOT EXISTS idx_snippet_tombstones_ended_at ON snippet_tombstones (ended_at);
+
+-- Snippets that burnt before this migration get their tombstone now
+INSERT INTO snippet_tombstones (snippet_id, user_id, title, language, kind, views, max_views, link_views, reason, created_at, burnt_at, ended_at)
+SELECT id, user_id, title, language, kind, current_views, max_views,
+    COALESCE((SELECT SUM(current_views) FROM snippet_links WHERE snippet_links.snippet_id = snippets.id), 0),
+    CASE WHEN passphrase_protected AND failed_attempts >= max_attempts THEN 'passphrase_attempts' ELSE 'max_views' END,
+    created_at, burnt_at, burnt_at
+FROM snippets
+WHERE burnt_at IS NOT NULL AND user_id IS NOT NULL
+ON CONFLICT (snippet_id) DO NOTHING;
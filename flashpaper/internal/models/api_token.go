@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a personal API token can be granted. Browser sessions are not scoped.
+const (
+	ScopeSnippetsCreate  = "snippets:create"
+	ScopeSnippetsReadOwn = "snippets:read-own"
+	ScopeSnippetsDelete  = "snippets:delete"
+	ScopeLinksManage     = "links:manage"
+	ScopeWebhooksManage  = "webhooks:manage"
+	ScopeDashboardRead   = "dashboard:read"
+)
+
+// APIToken is a long-lived personal token for scripts and CI, used as a bearer token
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID     uuid.UUID  `gorm:"type:uuid;index;not null"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	Name       string     `gorm:"not null"`
+	Prefix     string     `gorm:"not null"`             // First characters of the token, to tell tokens apart
+	TokenHash  string     `gorm:"uniqueIndex;not null"` // SHA-256, the token itself is never stored
+	Scopes     string     `gorm:"not null"`             // Comma separated scopes
+	ExpiresAt  *time.Time // nil never expires
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
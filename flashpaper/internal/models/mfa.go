@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecoveryCode is a one-time fallback for a lost authenticator
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null"`
+	User      User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	CodeHash  string     `gorm:"not null"`                                      // SHA-256 of the normalized code
+	UsedAt    *time.Time // Spent codes are kept so the remaining count is accurate
+	CreatedAt time.Time
+}
+
+// MFAChallenge is the second step of a login, issued once the password matched
+type MFAChallenge struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	TokenHash string    `gorm:"uniqueIndex;not null"`                          // SHA-256, the token itself is never stored
+	Attempts  int       `gorm:"default:0"`                                     // Wrong codes so far
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RetiredBlob is a blob that no snippet points at anymore, though a download
+// that started before it was replaced may still be reading it. The janitor
+// deletes it once the grace period is over.
+type RetiredBlob struct {
+	BlobKey   string    `gorm:"primaryKey"`
+	RetiredAt time.Time `gorm:"index;not null"`
+}
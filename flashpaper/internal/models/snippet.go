@@ -6,15 +6,42 @@ import (
 	"github.com/google/uuid"
 )
 
+// How a snippet's Content is protected
+const (
+	// Content is encrypted by the server with the keyring
+	EncryptionServer = "server"
+	// Content is an opaque envelope encrypted in the browser, the server never sees the key
+	EncryptionClient = "client"
+)
+
+// What a snippet holds
+const (
+	// Content is the (encrypted) text itself
+	KindText = "text"
+	// Content is an encrypted file manifest, the body lives in the blob store under BlobKey
+	KindFile = "file"
+	// Content is an encrypted JSON list of named files, revealed together under one view count
+	KindBundle = "bundle"
+)
+
 type Snippet struct {
-	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primary_key;"`
-	UserID       uuid.UUID `gorm:"type:uuid;index"`
-	User         User      `gorm:"foreignKey:UserID"` // Virtual field (populated only when preloaded, not stored in DB)
-	Content      string    `gorm:"not null"`
-	Title        string
-	Language     string
-	CurrentViews int       `gorm:"default:0"`
-	MaxViews     int       `gorm:"default:0"`
-	ExpiresAt    time.Time `gorm:"index"`
-	CreatedAt    time.Time
+	ID                  uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primary_key;"`
+	UserID              uuid.UUID `gorm:"type:uuid;index"`
+	User                User      `gorm:"foreignKey:UserID"` // Virtual field (populated only when preloaded, not stored in DB)
+	Content             string    `gorm:"not null"`
+	Encryption          string    `gorm:"not null;default:server"`
+	Kind                string    `gorm:"not null;default:text"`
+	BlobKey             string
+	FileSize            int64
+	Title               string
+	Language            string
+	CurrentViews        int        `gorm:"default:0"`
+	MaxViews            int        `gorm:"default:0"`
+	PassphraseProtected bool       `gorm:"default:false"`
+	FailedAttempts      int        `gorm:"default:0"`
+	MaxAttempts         int        `gorm:"default:0"`     // Wrong passphrases allowed before the snippet burns
+	Restricted          bool       `gorm:"default:false"` // Only the owner and SnippetAllowedUser rows may reveal
+	ExpiresAt           time.Time  `gorm:"index"`
+	BurntAt             *time.Time `gorm:"index"` // Set when the last view or passphrase attempt is used up
+	CreatedAt           time.Time
 }
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SnippetAllowedUser grants one registered user access to a restricted snippet
+type SnippetAllowedUser struct {
+	SnippetID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Snippet   Snippet   `gorm:"foreignKey:SnippetID;constraint:OnDelete:CASCADE"` // Virtual field
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	CreatedAt time.Time
+}
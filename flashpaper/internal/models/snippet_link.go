@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SnippetLink is an extra access link to a snippet for one recipient, with its own
+// view budget and expiry. Views through a link never touch the snippet's own counter.
+type SnippetLink struct {
+	ID           uuid.UUID         `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	SnippetID    uuid.UUID         `gorm:"type:uuid;index;not null"`
+	Snippet      Snippet           `gorm:"foreignKey:SnippetID;constraint:OnDelete:CASCADE"` // Virtual field
+	Label        string            `gorm:"not null"`
+	CurrentViews int               `gorm:"default:0"`
+	MaxViews     int               `gorm:"default:0"`
+	ExpiresAt    time.Time         `gorm:"index"`
+	RevokedAt    *time.Time        // Set when the owner cuts this recipient off
+	Views        []SnippetLinkView `gorm:"foreignKey:LinkID;constraint:OnDelete:CASCADE"` // Virtual field
+	CreatedAt    time.Time
+}
+
+// SnippetLinkView is the audit record of one view consumed through a link
+type SnippetLinkView struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	LinkID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	IP        string
+	UserAgent string
+	ViewedAt  time.Time
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Why a snippet's content is gone
+const (
+	TombstoneMaxViews           = "max_views"
+	TombstonePassphraseAttempts = "passphrase_attempts"
+	TombstoneExpired            = "expired"
+	TombstoneDeleted            = "deleted"
+)
+
+// SnippetTombstone is what the owner's history keeps of a snippet once its
+// content is gone. It never holds content, file names or passphrases.
+type SnippetTombstone struct {
+	SnippetID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	Title     string
+	Language  string
+	Kind      string    `gorm:"not null;default:text"`
+	Views     int       `gorm:"default:0"` // Views the snippet served itself
+	MaxViews  int       `gorm:"default:0"`
+	LinkViews int       `gorm:"default:0"` // Views served through recipient links
+	Reason    string    `gorm:"not null"`
+	CreatedAt time.Time // Of the snippet
+	BurntAt   *time.Time
+	ExpiredAt *time.Time
+	EndedAt   time.Time `gorm:"index"` // When the content went, retention counts from here
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is one link of a rotating refresh token chain. Every refresh
+// replaces the token with a new one of the same family (one family per login),
+// so a used token showing up again means it was stolen.
+type RefreshToken struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID          uuid.UUID  `gorm:"type:uuid;index;not null"`
+	User            User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	FamilyID        uuid.UUID  `gorm:"type:uuid;index;not null"`
+	TokenHash       string     `gorm:"uniqueIndex;not null"` // SHA-256, the token itself is never stored
+	AccessTokenID   string     // jti of the access token issued together with it
+	AccessExpiresAt time.Time  // When that access token expires anyway
+	ExpiresAt       time.Time  `gorm:"index"`
+	UsedAt          *time.Time // Set once it was exchanged for a new pair
+	RevokedAt       *time.Time // Set by logout or reuse detection
+	CreatedAt       time.Time
+}
+
+// RevokedToken denylists an access token (by jti) until it would have expired anyway
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
@@ -7,9 +7,15 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	Email     string    `gorm:"uniqueIndex;not null"`
-	Password  string    `gorm:"not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Email           string     `gorm:"uniqueIndex;not null"`
+	Password        string     `gorm:"not null"`
+	EmailVerifiedAt *time.Time // Set once the user followed the verification link
+	TOTPSecret      string     // Encrypted with the keyring, set from enrollment on
+	TOTPEnabled     bool       `gorm:"default:false"` // Only true once a code was confirmed
+	TOTPLastStep    int64      // Last accepted time step, a code is never accepted twice
+	FailedLogins    int        `gorm:"not null;default:0"` // Wrong passwords since the last successful login
+	LockedUntil     *time.Time // Logins are refused until then
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What a UserToken proves
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+// UserToken is a single-use token mailed to a user (email verification, password reset)
+type UserToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null"`
+	User      User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	Purpose   string     `gorm:"not null"`
+	TokenHash string     `gorm:"uniqueIndex;not null"` // SHA-256, the token itself is never stored
+	ExpiresAt time.Time  `gorm:"index"`
+	UsedAt    *time.Time // Set once redeemed (or superseded by a newer token)
+	CreatedAt time.Time
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events a webhook can subscribe to
+const (
+	EventSnippetViewed  = "snippet.viewed"
+	EventSnippetBurnt   = "snippet.burnt"
+	EventSnippetExpired = "snippet.expired"
+	EventSnippetDeleted = "snippet.deleted"
+)
+
+// States of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// Gave up after the last retry
+	DeliveryFailed = "failed"
+)
+
+// Webhook is an endpoint of a user that receives signed snippet events
+type Webhook struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Virtual field
+	URL       string    `gorm:"not null"`
+	Secret    string    `gorm:"not null"` // HMAC signing secret, encrypted with the keyring
+	Events    string    `gorm:"not null"` // Comma separated event names
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is one event queued for one webhook (the outbox) and its delivery log
+type WebhookDelivery struct {
+	ID             uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	WebhookID      uuid.UUID  `gorm:"type:uuid;index;not null"`
+	Webhook        Webhook    `gorm:"foreignKey:WebhookID;constraint:OnDelete:CASCADE"` // Virtual field
+	Event          string     `gorm:"not null"`
+	Payload        string     `gorm:"type:text;not null"`
+	Status         string     `gorm:"not null;default:pending;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts       int        `gorm:"default:0"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_webhook_deliveries_due,priority:2"`
+	LastStatusCode int        // HTTP status of the last attempt, 0 if the request never got a response
+	LastError      string     // Why the last attempt failed
+	DeliveredAt    *time.Time // Set once an attempt got a 2xx
+	CreatedAt      time.Time
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests may arrive at once, and the bucket
+// refills at Burst requests per Period.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Period: time.Minute}
+}
+
+func PerHour(n int) Limit {
+	return Limit{Burst: n, Period: time.Hour}
+}
+
+// interval is the time it takes to refill one request
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// Result is the outcome of one request against a bucket
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next request is allowed, zero when Allowed
+}
+
+// Store keeps the buckets. MemoryStore works for a single instance, RedisStore
+// shares the buckets between instances.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// take spends one request from a bucket that held tokens at last and returns
+// the new token count. Shared by the stores so they count the same way.
+func take(tokens float64, last, now time.Time, limit Limit) (float64, Result) {
+	burst := float64(limit.Burst)
+	perToken := float64(limit.interval())
+
+	// Refill for the time that passed
+	if elapsed := now.Sub(last); elapsed > 0 {
+		tokens = math.Min(burst, tokens+float64(elapsed)/perToken)
+	}
+
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+
+	result.Remaining = int(tokens)
+	result.ResetAfter = time.Duration((burst - tokens) * perToken)
+	return tokens, result
+}
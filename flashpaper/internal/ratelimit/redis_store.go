@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The bucket lives in a hash and is updated atomically by the script, using the
+// server clock so instances with skewed clocks still agree. It mirrors take().
+var tokenBucket = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local per_token = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+if now > last then
+  tokens = math.min(burst, tokens + (now - last) / per_token)
+end
+
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry_after = math.ceil((1 - tokens) * per_token)
+end
+
+local reset_after = math.ceil((burst - tokens) * per_token)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.max(1, math.ceil(reset_after / 1000)))
+
+return {allowed, math.floor(tokens), reset_after, retry_after}
+`)
+
+// RedisStore shares buckets between instances through Redis, or anything
+// speaking its protocol and scripting (Valkey, KeyDB, Dragonfly...).
+type RedisStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+func NewRedisStore(client redis.Scripter) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: "flashpaper:ratelimit:",
+	}
+}
+
+// NewRedisStoreFromURL connects to a redis:// or rediss:// URL
+func NewRedisStoreFromURL(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisStore(redis.NewClient(options)), nil
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	perToken := limit.interval().Microseconds()
+	if perToken < 1 {
+		perToken = 1
+	}
+
+	values, err := tokenBucket.Run(ctx, s.client, []string{s.prefix + key}, limit.Burst, perToken).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit.Burst,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,525 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/tracing"
+)
+
+// NewGorm returns repositories on a SQL database. They avoid dialect specific SQL,
+// so the same code serves Postgres and SQLite. The one explicit row lock,
+// GetForUpdate, is left out on SQLite, where writers take turns anyway.
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Snippets:   gormSnippets{db: db},
+		Tombstones: gormTombstones{db: db},
+		Links:      gormLinks{db: db},
+		Allowlist:  gormAllowlist{db: db},
+		Users:      gormUsers{db: db},
+		Transactor: gormTransactor{db: db},
+	}
+}
+
+type txKey struct{}
+
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+func (t gormTransactor) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+
+	// The queries of the transaction become children of its span, which makes
+	// the time a reveal holds its row lock visible
+	ctx, span := tracing.Start(ctx, "db.transaction")
+	err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+	tracing.End(span, err)
+	return err
+}
+
+// Conn returns the transaction running in ctx, or db outside of one. Services use
+// it for their own tables so those writes commit or roll back with the repositories'.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type gormSnippets struct {
+	db *gorm.DB
+}
+
+func (r gormSnippets) Create(ctx context.Context, snippet *models.Snippet) error {
+	return Conn(ctx, r.db).Create(snippet).Error
+}
+
+func (r gormSnippets) Get(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	var snippet models.Snippet
+	if err := Conn(ctx, r.db).Where("id = ?", id).First(&snippet).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &snippet, nil
+}
+
+func (r gormSnippets) GetForUpdate(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	var snippet models.Snippet
+	if err := Conn(ctx, r.db).
+		Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("id = ?", id).
+		First(&snippet).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &snippet, nil
+}
+
+func (r gormSnippets) ConsumeView(ctx context.Context, id uuid.UUID, now time.Time) (*models.Snippet, error) {
+	// The conditions and the increment are one statement, the database does the locking
+	result := Conn(ctx, r.db).
+		Model(&models.Snippet{}).
+		Where("id = ? AND current_views < max_views AND expires_at > ?", id, now).
+		Update("current_views", gorm.Expr("current_views + 1"))
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	snippet, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		if err := checkLive(snippet, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrBurnt
+	}
+
+	return snippet, nil
+}
+
+func (r gormSnippets) RecordFailedAttempt(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	// Both SET expressions see the old row, so the burn uses the new attempt count
+	result := Conn(ctx, r.db).
+		Model(&models.Snippet{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"failed_attempts": gorm.Expr("failed_attempts + 1"),
+			"current_views":   gorm.Expr("CASE WHEN failed_attempts + 1 >= max_attempts THEN max_views ELSE current_views END"),
+		})
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return r.Get(ctx, id)
+}
+
+func (r gormSnippets) Purge(ctx context.Context, id uuid.UUID, now time.Time) error {
+	result := Conn(ctx, r.db).
+		Model(&models.Snippet{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"content":  "",
+			"burnt_at": now,
+		})
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormSnippets) Delete(ctx context.Context, id, ownerID uuid.UUID) error {
+	result := Conn(ctx, r.db).Where("id = ? AND user_id = ?", id, ownerID).Delete(&models.Snippet{})
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormSnippets) ListActive(ctx context.Context, ownerID uuid.UUID, now time.Time, offset, limit int) ([]models.Snippet, int64, error) {
+	var snippets []models.Snippet
+	var total int64
+
+	query := Conn(ctx, r.db).
+		Model(&models.Snippet{}).
+		Where("user_id = ?", ownerID).
+		Where("expires_at > ?", now)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if err := query.
+		Select("id", "title", "language", "kind", "file_size", "max_views", "current_views", "expires_at", "created_at").
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&snippets).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return snippets, total, nil
+}
+
+func (r gormSnippets) Stats(ctx context.Context, ownerID uuid.UUID, now time.Time) (*SnippetStats, error) {
+	var stats SnippetStats
+	owned := Conn(ctx, r.db).Model(&models.Snippet{}).Where("user_id = ? AND expires_at > ?", ownerID, now)
+
+	if err := owned.Session(&gorm.Session{}).
+		Where("current_views < max_views").
+		Count(&stats.Active).Error; err != nil {
+		return nil, err
+	}
+
+	if err := owned.Session(&gorm.Session{}).
+		Where("current_views >= max_views").
+		Count(&stats.Burnt).Error; err != nil {
+		return nil, err
+	}
+
+	var totalViews *int64
+	if err := owned.Session(&gorm.Session{}).
+		Where("current_views < max_views").
+		Select("SUM(current_views)").
+		Scan(&totalViews).Error; err != nil {
+		return nil, err
+	}
+	if totalViews != nil {
+		stats.TotalViews = *totalViews
+	}
+
+	return &stats, nil
+}
+
+func (r gormSnippets) ListPurgeable(ctx context.Context, filter PurgeFilter) ([]models.Snippet, error) {
+	query := Conn(ctx, r.db).
+		Select("id", "user_id", "title", "language", "kind", "blob_key", "current_views", "max_views",
+			"passphrase_protected", "failed_attempts", "max_attempts", "expires_at", "burnt_at", "created_at").
+		Where("expires_at < ? OR burnt_at < ?", filter.ExpiredBefore, filter.BurntBefore)
+	if len(filter.Skip) > 0 {
+		query = query.Where("id NOT IN ?", filter.Skip)
+	}
+
+	var snippets []models.Snippet
+	if err := query.Order("expires_at").Limit(filter.Limit).Find(&snippets).Error; err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+func (r gormSnippets) DeleteByIDs(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := Conn(ctx, r.db).Where("id IN ?", ids).Delete(&models.Snippet{})
+	return result.RowsAffected, result.Error
+}
+
+func (r gormSnippets) ListStale(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error) {
+	// Client side encrypted snippets never touch the keyring, burnt ones have no content left
+	query := Conn(ctx, r.db).
+		Model(&models.Snippet{}).
+		Where("encryption = ? AND expires_at > ? AND burnt_at IS NULL", models.EncryptionServer, filter.Now).
+		Where(NotSealedWith("content", filter.KeyID))
+	if len(filter.Skip) > 0 {
+		query = query.Where("id NOT IN ?", filter.Skip)
+	}
+
+	var ids []uuid.UUID
+	if err := query.Order("created_at").Limit(filter.Limit).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r gormSnippets) Reseal(ctx context.Context, id uuid.UUID, content, blobKey string) error {
+	updates := map[string]interface{}{"content": content}
+	if blobKey != "" {
+		updates["blob_key"] = blobKey
+	}
+	return Conn(ctx, r.db).Model(&models.Snippet{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// NotSealedWith matches rows whose column is not tagged with keyID, see sealedWith.
+// It is exported for the tables key rotation reaches without a repository.
+func NotSealedWith(column, keyID string) clause.Expr {
+	prefix := keyID + ":"
+	return gorm.Expr("substr("+column+", 1, ?) <> ?", len(prefix), prefix)
+}
+
+type gormTombstones struct {
+	db *gorm.DB
+}
+
+func (r gormTombstones) Create(ctx context.Context, tombstone *models.SnippetTombstone) error {
+	return Conn(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).Create(tombstone).Error
+}
+
+func (r gormTombstones) List(ctx context.Context, ownerID uuid.UUID, offset, limit int) ([]models.SnippetTombstone, int64, error) {
+	var tombstones []models.SnippetTombstone
+	var total int64
+
+	query := Conn(ctx, r.db).
+		Model(&models.SnippetTombstone{}).
+		Where("user_id = ?", ownerID)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if err := query.
+		Order("ended_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&tombstones).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return tombstones, total, nil
+}
+
+func (r gormTombstones) DeleteEndedBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	db := Conn(ctx, r.db)
+	batch := db.Model(&models.SnippetTombstone{}).Select("snippet_id").Where("ended_at < ?", before).Limit(limit)
+
+	result := db.Where("snippet_id IN (?)", batch).Delete(&models.SnippetTombstone{})
+	return result.RowsAffected, result.Error
+}
+
+type gormLinks struct {
+	db *gorm.DB
+}
+
+func (r gormLinks) Create(ctx context.Context, link *models.SnippetLink) error {
+	return Conn(ctx, r.db).Create(link).Error
+}
+
+func (r gormLinks) Get(ctx context.Context, id uuid.UUID) (*models.SnippetLink, error) {
+	var link models.SnippetLink
+	if err := Conn(ctx, r.db).Where("id = ?", id).First(&link).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &link, nil
+}
+
+func (r gormLinks) List(ctx context.Context, snippetID uuid.UUID) ([]models.SnippetLink, error) {
+	var links []models.SnippetLink
+	if err := Conn(ctx, r.db).
+		Preload("Views", func(db *gorm.DB) *gorm.DB { return db.Order("viewed_at") }).
+		Where("snippet_id = ?", snippetID).
+		Order("created_at").
+		Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (r gormLinks) Revoke(ctx context.Context, id, snippetID uuid.UUID, now time.Time) error {
+	result := Conn(ctx, r.db).
+		Model(&models.SnippetLink{}).
+		Where("id = ? AND snippet_id = ? AND revoked_at IS NULL", id, snippetID).
+		Update("revoked_at", now)
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormLinks) ConsumeView(ctx context.Context, id uuid.UUID, view *models.SnippetLinkView) error {
+	// Check and take the view in a single statement, like a snippet's own views
+	result := Conn(ctx, r.db).
+		Model(&models.SnippetLink{}).
+		Where("id = ? AND current_views < max_views AND revoked_at IS NULL", id).
+		Update("current_views", gorm.Expr("current_views + 1"))
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		if _, err := r.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrBurnt
+	}
+
+	view.LinkID = id
+	return Conn(ctx, r.db).Create(view).Error
+}
+
+func (r gormLinks) CountLive(ctx context.Context, snippetID uuid.UUID, now time.Time) (int64, error) {
+	var live int64
+	err := Conn(ctx, r.db).
+		Model(&models.SnippetLink{}).
+		Where("snippet_id = ? AND revoked_at IS NULL AND current_views < max_views AND expires_at > ?", snippetID, now).
+		Count(&live).Error
+	return live, err
+}
+
+func (r gormLinks) SumViews(ctx context.Context, snippetID uuid.UUID) (int, error) {
+	var views *int64
+	if err := Conn(ctx, r.db).
+		Model(&models.SnippetLink{}).
+		Where("snippet_id = ?", snippetID).
+		Select("SUM(current_views)").
+		Scan(&views).Error; err != nil {
+		return 0, err
+	}
+	if views == nil {
+		return 0, nil
+	}
+	return int(*views), nil
+}
+
+type gormAllowlist struct {
+	db *gorm.DB
+}
+
+func (r gormAllowlist) Add(ctx context.Context, snippetID uuid.UUID, userIDs []uuid.UUID) error {
+	for _, userID := range userIDs {
+		if err := Conn(ctx, r.db).Create(&models.SnippetAllowedUser{SnippetID: snippetID, UserID: userID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r gormAllowlist) Contains(ctx context.Context, snippetID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := Conn(ctx, r.db).
+		Model(&models.SnippetAllowedUser{}).
+		Where("snippet_id = ? AND user_id = ?", snippetID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r gormUsers) Create(ctx context.Context, user *models.User) error {
+	return Conn(ctx, r.db).Create(user).Error
+}
+
+func (r gormUsers) Get(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := Conn(ctx, r.db).Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := Conn(ctx, r.db).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) GetByEmailFold(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := Conn(ctx, r.db).Where("LOWER(email) = ?", strings.ToLower(email)).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int, error) {
+	// Increment in SQL, concurrent guesses must all be counted
+	if err := Conn(ctx, r.db).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("failed_logins", gorm.Expr("failed_logins + 1")).Error; err != nil {
+		return 0, err
+	}
+
+	var failures []int
+	if err := Conn(ctx, r.db).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Pluck("failed_logins", &failures).Error; err != nil {
+		return 0, err
+	}
+	if len(failures) == 0 {
+		return 0, ErrNotFound
+	}
+	return failures[0], nil
+}
+
+func (r gormUsers) Lock(ctx context.Context, id uuid.UUID, until time.Time) error {
+	return Conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id).Update("locked_until", until).Error
+}
+
+func (r gormUsers) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	return Conn(ctx, r.db).Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
+
+func (r gormUsers) ListStaleTOTP(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error) {
+	query := Conn(ctx, r.db).
+		Model(&models.User{}).
+		Where("totp_secret <> ''").
+		Where(NotSealedWith("totp_secret", filter.KeyID))
+	if len(filter.Skip) > 0 {
+		query = query.Where("id NOT IN ?", filter.Skip)
+	}
+
+	var ids []uuid.UUID
+	if err := query.Order("created_at").Limit(filter.Limit).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r gormUsers) ResealTOTP(ctx context.Context, id uuid.UUID, reseal func(sealed string) (string, error)) error {
+	return Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id", "totp_secret").
+			Where("id = ?", id).
+			First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		// Two-factor auth may have been disabled meanwhile
+		if user.TOTPSecret == "" {
+			return nil
+		}
+
+		sealed, err := reseal(user.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("totp_secret", sealed).Error
+	})
+}
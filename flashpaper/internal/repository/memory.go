@@ -0,0 +1,600 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/models"
+)
+
+// NewMemory returns repositories that keep everything in process, for tests and
+// throwaway instances. Transactions are serialized and roll back on error.
+func NewMemory() Repositories {
+	store := &memoryStore{memoryData: memoryData{
+		snippets:   map[uuid.UUID]models.Snippet{},
+		tombstones: map[uuid.UUID]models.SnippetTombstone{},
+		links:      map[uuid.UUID]models.SnippetLink{},
+		allowed:    map[allowedKey]bool{},
+		users:      map[uuid.UUID]models.User{},
+	}}
+	return Repositories{
+		Snippets:   memorySnippets{store},
+		Tombstones: memoryTombstones{store},
+		Links:      memoryLinks{store},
+		Allowlist:  memoryAllowlist{store},
+		Users:      memoryUsers{store},
+		Transactor: store,
+	}
+}
+
+type memoryTxKey struct{}
+
+// memoryStore holds copies, callers never share a struct with the store
+type memoryStore struct {
+	mu sync.Mutex
+	memoryData
+}
+
+type memoryData struct {
+	snippets   map[uuid.UUID]models.Snippet
+	tombstones map[uuid.UUID]models.SnippetTombstone
+	links      map[uuid.UUID]models.SnippetLink
+	linkViews  []models.SnippetLinkView
+	allowed    map[allowedKey]bool
+	users      map[uuid.UUID]models.User
+}
+
+// allowedKey is one entry of a snippet's allowlist
+type allowedKey struct {
+	snippetID, userID uuid.UUID
+}
+
+// clone copies what a transaction may change, so a failed one can be undone
+func (d memoryData) clone() memoryData {
+	return memoryData{
+		snippets:   maps.Clone(d.snippets),
+		tombstones: maps.Clone(d.tombstones),
+		links:      maps.Clone(d.links),
+		linkViews:  slices.Clone(d.linkViews),
+		allowed:    maps.Clone(d.allowed),
+		users:      maps.Clone(d.users),
+	}
+}
+
+// deleteSnippet removes a snippet with its links and allowlist, like the
+// foreign keys of the SQL schema do
+func (d *memoryData) deleteSnippet(id uuid.UUID) {
+	delete(d.snippets, id)
+	for linkID, link := range d.links {
+		if link.SnippetID == id {
+			delete(d.links, linkID)
+			d.linkViews = slices.DeleteFunc(d.linkViews, func(view models.SnippetLinkView) bool {
+				return view.LinkID == linkID
+			})
+		}
+	}
+	for entry := range d.allowed {
+		if entry.snippetID == id {
+			delete(d.allowed, entry)
+		}
+	}
+}
+
+// lock takes the store unless ctx belongs to a transaction that already holds it
+func (m *memoryStore) lock(ctx context.Context) func() {
+	if ctx.Value(memoryTxKey{}) == m {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+func (m *memoryStore) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) == m {
+		return fn(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := m.memoryData.clone()
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, m)); err != nil {
+		m.memoryData = saved
+		return err
+	}
+	return nil
+}
+
+type memorySnippets struct {
+	*memoryStore
+}
+
+func (r memorySnippets) Create(ctx context.Context, snippet *models.Snippet) error {
+	defer r.lock(ctx)()
+
+	if snippet.ID == uuid.Nil {
+		snippet.ID = uuid.New()
+	}
+	if snippet.CreatedAt.IsZero() {
+		snippet.CreatedAt = time.Now()
+	}
+	r.snippets[snippet.ID] = *snippet
+	return nil
+}
+
+func (r memorySnippets) Get(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &snippet, nil
+}
+
+// GetForUpdate needs no lock of its own, a transaction holds the whole store
+func (r memorySnippets) GetForUpdate(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	return r.Get(ctx, id)
+}
+
+func (r memorySnippets) ConsumeView(ctx context.Context, id uuid.UUID, now time.Time) (*models.Snippet, error) {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkLive(&snippet, now); err != nil {
+		return nil, err
+	}
+
+	snippet.CurrentViews++
+	r.snippets[id] = snippet
+	return &snippet, nil
+}
+
+func (r memorySnippets) RecordFailedAttempt(ctx context.Context, id uuid.UUID) (*models.Snippet, error) {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	snippet.FailedAttempts++
+	if snippet.FailedAttempts >= snippet.MaxAttempts {
+		snippet.CurrentViews = snippet.MaxViews
+	}
+	r.snippets[id] = snippet
+	return &snippet, nil
+}
+
+func (r memorySnippets) Purge(ctx context.Context, id uuid.UUID, now time.Time) error {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok {
+		return ErrNotFound
+	}
+	snippet.Content = ""
+	snippet.BurntAt = &now
+	r.snippets[id] = snippet
+	return nil
+}
+
+func (r memorySnippets) Delete(ctx context.Context, id, ownerID uuid.UUID) error {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok || snippet.UserID != ownerID {
+		return ErrNotFound
+	}
+	r.deleteSnippet(id)
+	return nil
+}
+
+func (r memorySnippets) ListActive(ctx context.Context, ownerID uuid.UUID, now time.Time, offset, limit int) ([]models.Snippet, int64, error) {
+	defer r.lock(ctx)()
+
+	var active []models.Snippet
+	for _, snippet := range r.snippets {
+		if snippet.UserID == ownerID && snippet.ExpiresAt.After(now) {
+			active = append(active, snippet)
+		}
+	}
+	slices.SortFunc(active, func(a, b models.Snippet) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	total := int64(len(active))
+	if offset >= len(active) {
+		return []models.Snippet{}, total, nil
+	}
+	return active[offset:min(offset+limit, len(active))], total, nil
+}
+
+func (r memorySnippets) Stats(ctx context.Context, ownerID uuid.UUID, now time.Time) (*SnippetStats, error) {
+	defer r.lock(ctx)()
+
+	var stats SnippetStats
+	for _, snippet := range r.snippets {
+		if snippet.UserID != ownerID || !snippet.ExpiresAt.After(now) {
+			continue
+		}
+		if snippet.CurrentViews >= snippet.MaxViews {
+			stats.Burnt++
+			continue
+		}
+		stats.Active++
+		stats.TotalViews += int64(snippet.CurrentViews)
+	}
+	return &stats, nil
+}
+
+func (r memorySnippets) ListPurgeable(ctx context.Context, filter PurgeFilter) ([]models.Snippet, error) {
+	defer r.lock(ctx)()
+
+	var purgeable []models.Snippet
+	for _, snippet := range r.snippets {
+		burnt := snippet.BurntAt != nil && snippet.BurntAt.Before(filter.BurntBefore)
+		if (snippet.ExpiresAt.Before(filter.ExpiredBefore) || burnt) && !slices.Contains(filter.Skip, snippet.ID) {
+			purgeable = append(purgeable, snippet)
+		}
+	}
+
+	slices.SortFunc(purgeable, func(a, b models.Snippet) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	if len(purgeable) > filter.Limit {
+		purgeable = purgeable[:filter.Limit]
+	}
+	return purgeable, nil
+}
+
+func (r memorySnippets) DeleteByIDs(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	defer r.lock(ctx)()
+
+	var deleted int64
+	for _, id := range ids {
+		if _, ok := r.snippets[id]; ok {
+			r.deleteSnippet(id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r memorySnippets) ListStale(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error) {
+	defer r.lock(ctx)()
+
+	var stale []models.Snippet
+	for _, snippet := range r.snippets {
+		live := snippet.Encryption == models.EncryptionServer && snippet.ExpiresAt.After(filter.Now) && snippet.BurntAt == nil
+		if live && !sealedWith(snippet.Content, filter.KeyID) && !slices.Contains(filter.Skip, snippet.ID) {
+			stale = append(stale, snippet)
+		}
+	}
+
+	slices.SortFunc(stale, func(a, b models.Snippet) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	ids := make([]uuid.UUID, 0, min(len(stale), filter.Limit))
+	for _, snippet := range stale[:min(len(stale), filter.Limit)] {
+		ids = append(ids, snippet.ID)
+	}
+	return ids, nil
+}
+
+func (r memorySnippets) Reseal(ctx context.Context, id uuid.UUID, content, blobKey string) error {
+	defer r.lock(ctx)()
+
+	snippet, ok := r.snippets[id]
+	if !ok {
+		return ErrNotFound
+	}
+	snippet.Content = content
+	if blobKey != "" {
+		snippet.BlobKey = blobKey
+	}
+	r.snippets[id] = snippet
+	return nil
+}
+
+type memoryTombstones struct {
+	*memoryStore
+}
+
+func (r memoryTombstones) Create(ctx context.Context, tombstone *models.SnippetTombstone) error {
+	defer r.lock(ctx)()
+
+	if _, exists := r.tombstones[tombstone.SnippetID]; !exists {
+		r.tombstones[tombstone.SnippetID] = *tombstone
+	}
+	return nil
+}
+
+func (r memoryTombstones) List(ctx context.Context, ownerID uuid.UUID, offset, limit int) ([]models.SnippetTombstone, int64, error) {
+	defer r.lock(ctx)()
+
+	var owned []models.SnippetTombstone
+	for _, tombstone := range r.tombstones {
+		if tombstone.UserID == ownerID {
+			owned = append(owned, tombstone)
+		}
+	}
+	slices.SortFunc(owned, func(a, b models.SnippetTombstone) int {
+		return b.EndedAt.Compare(a.EndedAt)
+	})
+
+	total := int64(len(owned))
+	if offset >= len(owned) {
+		return []models.SnippetTombstone{}, total, nil
+	}
+	return owned[offset:min(offset+limit, len(owned))], total, nil
+}
+
+func (r memoryTombstones) DeleteEndedBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	defer r.lock(ctx)()
+
+	var deleted int64
+	for id, tombstone := range r.tombstones {
+		if deleted >= int64(limit) {
+			break
+		}
+		if tombstone.EndedAt.Before(before) {
+			delete(r.tombstones, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type memoryLinks struct {
+	*memoryStore
+}
+
+func (r memoryLinks) Create(ctx context.Context, link *models.SnippetLink) error {
+	defer r.lock(ctx)()
+
+	if link.ID == uuid.Nil {
+		link.ID = uuid.New()
+	}
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	r.links[link.ID] = *link
+	return nil
+}
+
+func (r memoryLinks) Get(ctx context.Context, id uuid.UUID) (*models.SnippetLink, error) {
+	defer r.lock(ctx)()
+
+	link, ok := r.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &link, nil
+}
+
+func (r memoryLinks) List(ctx context.Context, snippetID uuid.UUID) ([]models.SnippetLink, error) {
+	defer r.lock(ctx)()
+
+	var links []models.SnippetLink
+	for _, link := range r.links {
+		if link.SnippetID != snippetID {
+			continue
+		}
+		for _, view := range r.linkViews {
+			if view.LinkID == link.ID {
+				link.Views = append(link.Views, view)
+			}
+		}
+		links = append(links, link)
+	}
+	slices.SortFunc(links, func(a, b models.SnippetLink) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return links, nil
+}
+
+func (r memoryLinks) Revoke(ctx context.Context, id, snippetID uuid.UUID, now time.Time) error {
+	defer r.lock(ctx)()
+
+	link, ok := r.links[id]
+	if !ok || link.SnippetID != snippetID || link.RevokedAt != nil {
+		return ErrNotFound
+	}
+	link.RevokedAt = &now
+	r.links[id] = link
+	return nil
+}
+
+func (r memoryLinks) ConsumeView(ctx context.Context, id uuid.UUID, view *models.SnippetLinkView) error {
+	defer r.lock(ctx)()
+
+	link, ok := r.links[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if link.RevokedAt != nil || link.CurrentViews >= link.MaxViews {
+		return ErrBurnt
+	}
+	link.CurrentViews++
+	r.links[id] = link
+
+	view.LinkID = id
+	if view.ID == uuid.Nil {
+		view.ID = uuid.New()
+	}
+	r.linkViews = append(r.linkViews, *view)
+	return nil
+}
+
+func (r memoryLinks) CountLive(ctx context.Context, snippetID uuid.UUID, now time.Time) (int64, error) {
+	defer r.lock(ctx)()
+
+	var live int64
+	for _, link := range r.links {
+		if link.SnippetID == snippetID && link.RevokedAt == nil && link.CurrentViews < link.MaxViews && link.ExpiresAt.After(now) {
+			live++
+		}
+	}
+	return live, nil
+}
+
+func (r memoryLinks) SumViews(ctx context.Context, snippetID uuid.UUID) (int, error) {
+	defer r.lock(ctx)()
+
+	var views int
+	for _, link := range r.links {
+		if link.SnippetID == snippetID {
+			views += link.CurrentViews
+		}
+	}
+	return views, nil
+}
+
+type memoryAllowlist struct {
+	*memoryStore
+}
+
+func (r memoryAllowlist) Add(ctx context.Context, snippetID uuid.UUID, userIDs []uuid.UUID) error {
+	defer r.lock(ctx)()
+
+	for _, userID := range userIDs {
+		r.allowed[allowedKey{snippetID, userID}] = true
+	}
+	return nil
+}
+
+func (r memoryAllowlist) Contains(ctx context.Context, snippetID, userID uuid.UUID) (bool, error) {
+	defer r.lock(ctx)()
+
+	return r.allowed[allowedKey{snippetID, userID}], nil
+}
+
+type memoryUsers struct {
+	*memoryStore
+}
+
+func (r memoryUsers) Create(ctx context.Context, user *models.User) error {
+	defer r.lock(ctx)()
+
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r memoryUsers) Get(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r memoryUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryUsers) GetByEmailFold(ctx context.Context, email string) (*models.User, error) {
+	defer r.lock(ctx)()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryUsers) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int, error) {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	user.FailedLogins++
+	r.users[id] = user
+	return user.FailedLogins, nil
+}
+
+func (r memoryUsers) Lock(ctx context.Context, id uuid.UUID, until time.Time) error {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.LockedUntil = &until
+	r.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	r.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) ListStaleTOTP(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error) {
+	defer r.lock(ctx)()
+
+	var stale []models.User
+	for _, user := range r.users {
+		if user.TOTPSecret != "" && !sealedWith(user.TOTPSecret, filter.KeyID) && !slices.Contains(filter.Skip, user.ID) {
+			stale = append(stale, user)
+		}
+	}
+
+	slices.SortFunc(stale, func(a, b models.User) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	ids := make([]uuid.UUID, 0, min(len(stale), filter.Limit))
+	for _, user := range stale[:min(len(stale), filter.Limit)] {
+		ids = append(ids, user.ID)
+	}
+	return ids, nil
+}
+
+func (r memoryUsers) ResealTOTP(ctx context.Context, id uuid.UUID, reseal func(sealed string) (string, error)) error {
+	defer r.lock(ctx)()
+
+	user, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if user.TOTPSecret == "" {
+		return nil
+	}
+
+	sealed, err := reseal(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = sealed
+	r.users[id] = user
+	return nil
+}
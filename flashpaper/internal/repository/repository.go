@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/models"
+)
+
+// Errors share their text with what the handlers already switch on
+var (
+	ErrNotFound = errors.New("not_found")
+	ErrExpired  = errors.New("expired")
+	ErrBurnt    = errors.New("burnt")
+)
+
+// Transactor runs fn atomically. Repository calls made with the ctx handed to fn
+// take part in the transaction, a Transaction inside fn joins it.
+type Transactor interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// SnippetRepository stores snippets. View and attempt counters are only ever
+// changed through ConsumeView and RecordFailedAttempt, which check and update in
+// one atomic step, so concurrent reveals can never take more views than allowed.
+type SnippetRepository interface {
+	Create(ctx context.Context, snippet *models.Snippet) error
+	Get(ctx context.Context, id uuid.UUID) (*models.Snippet, error)
+	// GetForUpdate is Get holding the row until the transaction of ctx ends, so
+	// whoever decides to burn a snippet sees what concurrent reveals committed
+	GetForUpdate(ctx context.Context, id uuid.UUID) (*models.Snippet, error)
+	// ConsumeView takes one view of a live snippet and returns it updated, or
+	// ErrExpired/ErrBurnt when there was no view left to take
+	ConsumeView(ctx context.Context, id uuid.UUID, now time.Time) (*models.Snippet, error)
+	// RecordFailedAttempt counts a wrong passphrase and burns the snippet when
+	// it was the last attempt allowed
+	RecordFailedAttempt(ctx context.Context, id uuid.UUID) (*models.Snippet, error)
+	// Purge wipes the content of a snippet nobody can reveal anymore and stamps
+	// BurntAt. The row stays as a tombstone of its metadata, BlobKey included so
+	// the blob can still be removed.
+	Purge(ctx context.Context, id uuid.UUID, now time.Time) error
+	// Delete removes a snippet of ownerID, ErrNotFound when there is none
+	Delete(ctx context.Context, id, ownerID uuid.UUID) error
+	// ListActive pages through the unexpired snippets of ownerID, newest first
+	ListActive(ctx context.Context, ownerID uuid.UUID, now time.Time, offset, limit int) ([]models.Snippet, int64, error)
+	Stats(ctx context.Context, ownerID uuid.UUID, now time.Time) (*SnippetStats, error)
+	// ListPurgeable returns one batch of the snippets the janitor may remove, oldest expiry first
+	ListPurgeable(ctx context.Context, filter PurgeFilter) ([]models.Snippet, error)
+	// DeleteByIDs removes the given snippets and returns how many there were
+	DeleteByIDs(ctx context.Context, ids []uuid.UUID) (int64, error)
+	// ListStale returns one batch of live, unburnt, server encrypted snippets
+	// whose content is not sealed with filter.KeyID, oldest first
+	ListStale(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error)
+	// Reseal replaces the content of a snippet re-encrypted by key rotation, and
+	// its blob key unless blobKey is empty
+	Reseal(ctx context.Context, id uuid.UUID, content, blobKey string) error
+}
+
+// PurgeFilter selects snippets that expired before ExpiredBefore or burnt before
+// BurntBefore, at most Limit of them and none of Skip
+type PurgeFilter struct {
+	ExpiredBefore time.Time
+	BurntBefore   time.Time
+	Skip          []uuid.UUID
+	Limit         int
+}
+
+// StaleFilter selects rows whose ciphertext is not tagged with KeyID, at most
+// Limit of them and none of Skip. Snippets that expired before Now are left out.
+type StaleFilter struct {
+	KeyID string
+	Now   time.Time
+	Skip  []uuid.UUID
+	Limit int
+}
+
+// sealedWith reports whether a ciphertext is tagged with keyID. It compares a
+// prefix rather than matching a pattern, key IDs may contain LIKE wildcards.
+func sealedWith(cryptoText, keyID string) bool {
+	return strings.HasPrefix(cryptoText, keyID+":")
+}
+
+// SnippetStats summarizes the unexpired snippets of an owner
+type SnippetStats struct {
+	Active     int64 // Views left
+	Burnt      int64 // Read in full, waiting for expiry
+	TotalViews int64 // Across the active ones
+}
+
+// TombstoneRepository keeps the content-free records of snippets that are gone
+type TombstoneRepository interface {
+	// Create records a tombstone. A snippet that already has one keeps it, so the
+	// first reason its content went is the one remembered.
+	Create(ctx context.Context, tombstone *models.SnippetTombstone) error
+	// List pages through the tombstones of ownerID, most recently ended first
+	List(ctx context.Context, ownerID uuid.UUID, offset, limit int) ([]models.SnippetTombstone, int64, error)
+	// DeleteEndedBefore removes up to limit tombstones that ended before the given time
+	DeleteEndedBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// LinkRepository stores the recipient links of snippets and their audit trail.
+// A link's view counter is only ever changed through ConsumeView.
+type LinkRepository interface {
+	Create(ctx context.Context, link *models.SnippetLink) error
+	Get(ctx context.Context, id uuid.UUID) (*models.SnippetLink, error)
+	// List returns the links of a snippet with their views, oldest first
+	List(ctx context.Context, snippetID uuid.UUID) ([]models.SnippetLink, error)
+	// Revoke cuts off a link of snippetID, ErrNotFound when it has no unrevoked one
+	Revoke(ctx context.Context, id, snippetID uuid.UUID, now time.Time) error
+	// ConsumeView takes one view of an unrevoked link and records view as its
+	// audit entry, or returns ErrBurnt when there was no view left to take and
+	// ErrNotFound when there is no such link
+	ConsumeView(ctx context.Context, id uuid.UUID, view *models.SnippetLinkView) error
+	// CountLive counts the links of a snippet that can still serve a view
+	CountLive(ctx context.Context, snippetID uuid.UUID, now time.Time) (int64, error)
+	// SumViews is how many views the links of a snippet served
+	SumViews(ctx context.Context, snippetID uuid.UUID) (int, error)
+}
+
+// AllowlistRepository stores who may reveal a restricted snippet
+type AllowlistRepository interface {
+	Add(ctx context.Context, snippetID uuid.UUID, userIDs []uuid.UUID) error
+	Contains(ctx context.Context, snippetID, userID uuid.UUID) (bool, error)
+}
+
+// UserRepository stores accounts and their login state
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	Get(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	// GetByEmailFold is GetByEmail ignoring case, for addresses typed by someone else
+	GetByEmailFold(ctx context.Context, email string) (*models.User, error)
+	// RecordFailedLogin counts a wrong password and returns the new count
+	RecordFailedLogin(ctx context.Context, id uuid.UUID) (int, error)
+	Lock(ctx context.Context, id uuid.UUID, until time.Time) error
+	// ResetFailedLogins clears the counter and any lock
+	ResetFailedLogins(ctx context.Context, id uuid.UUID) error
+	// ListStaleTOTP returns one batch of users whose TOTP secret is set but not
+	// sealed with filter.KeyID, oldest first
+	ListStaleTOTP(ctx context.Context, filter StaleFilter) ([]uuid.UUID, error)
+	// ResealTOTP replaces the TOTP secret of a user with what reseal makes of it,
+	// holding the row meanwhile. A secret cleared in the meantime is left alone.
+	ResealTOTP(ctx context.Context, id uuid.UUID, reseal func(sealed string) (string, error)) error
+}
+
+// Repositories bundles the repositories of one backend with its transactions
+type Repositories struct {
+	Snippets   SnippetRepository
+	Tombstones TombstoneRepository
+	Links      LinkRepository
+	Allowlist  AllowlistRepository
+	Users      UserRepository
+	Transactor
+}
+
+// checkLive tells why a snippet has no view left, nil when it has one
+func checkLive(snippet *models.Snippet, now time.Time) error {
+	if now.After(snippet.ExpiresAt) {
+		return ErrExpired
+	}
+	if snippet.CurrentViews >= snippet.MaxViews {
+		return ErrBurnt
+	}
+	return nil
+}
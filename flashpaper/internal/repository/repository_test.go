@@ -0,0 +1,391 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/migrations"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+)
+
+// forEachBackend runs test against the in-memory repositories and the gorm ones
+// on a migrated SQLite database, both have to behave the same
+func forEachBackend(t *testing.T, test func(t *testing.T, repos repository.Repositories)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, repository.NewMemory())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		config.ConnectDB(config.Database{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "flashpaper.db")})
+		db := config.DB
+		t.Cleanup(func() {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+		})
+
+		migrator, err := migrations.New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		test(t, repository.NewGorm(db))
+	})
+}
+
+func newUser(t *testing.T, repos repository.Repositories, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, Password: "unused"}
+	if err := repos.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newSnippet stores a live server encrypted text snippet, change lets a test adjust it first
+func newSnippet(t *testing.T, repos repository.Repositories, owner *models.User, change func(*models.Snippet)) *models.Snippet {
+	t.Helper()
+
+	snippet := &models.Snippet{
+		UserID:     owner.ID,
+		Content:    "k1:sealed",
+		Encryption: models.EncryptionServer,
+		Kind:       models.KindText,
+		MaxViews:   1,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if change != nil {
+		change(snippet)
+	}
+	if err := repos.Snippets.Create(context.Background(), snippet); err != nil {
+		t.Fatal(err)
+	}
+	return snippet
+}
+
+func TestListStaleComparesKeyIDsLiterally(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+
+		// "_" is a LIKE wildcard, a pattern match would take kx1 for k_1
+		current := newSnippet(t, repos, owner, func(s *models.Snippet) { s.Content = "k_1:sealed" })
+		otherKey := newSnippet(t, repos, owner, func(s *models.Snippet) { s.Content = "kx1:sealed" })
+		legacy := newSnippet(t, repos, owner, func(s *models.Snippet) { s.Content = "c2VhbGVk" })
+		newSnippet(t, repos, owner, func(s *models.Snippet) { s.Encryption = models.EncryptionClient })
+		newSnippet(t, repos, owner, func(s *models.Snippet) { s.ExpiresAt = time.Now().Add(-time.Minute) })
+
+		ids, err := repos.Snippets.ListStale(ctx, repository.StaleFilter{KeyID: "k_1", Now: time.Now(), Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 2 || !slices.Contains(ids, otherKey.ID) || !slices.Contains(ids, legacy.ID) {
+			t.Fatalf("got %v, want only %s and %s (not %s)", ids, otherKey.ID, legacy.ID, current.ID)
+		}
+
+		ids, err = repos.Snippets.ListStale(ctx, repository.StaleFilter{
+			KeyID: "k_1", Now: time.Now(), Skip: []uuid.UUID{otherKey.ID}, Limit: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids, []uuid.UUID{legacy.ID}) {
+			t.Fatalf("skipping %s: got %v", otherKey.ID, ids)
+		}
+	})
+}
+
+func TestListStaleTOTPComparesKeyIDsLiterally(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+
+		users := map[string]*models.User{}
+		for _, secret := range []string{"k_1:sealed", "kx1:sealed", ""} {
+			user := &models.User{Email: "user" + secret + "@example.com", Password: "unused", TOTPSecret: secret}
+			if err := repos.Users.Create(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+			users[secret] = user
+		}
+
+		ids, err := repos.Users.ListStaleTOTP(ctx, repository.StaleFilter{KeyID: "k_1", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids, []uuid.UUID{users["kx1:sealed"].ID}) {
+			t.Fatalf("got %v, want only the user sealed with kx1", ids)
+		}
+
+		// Reseal holds the row and replaces the secret
+		err = repos.Users.ResealTOTP(ctx, users["kx1:sealed"].ID, func(sealed string) (string, error) {
+			return "k_1:" + sealed, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := repos.Users.Get(ctx, users["kx1:sealed"].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.TOTPSecret != "k_1:kx1:sealed" {
+			t.Fatalf("got secret %q after reseal", user.TOTPSecret)
+		}
+	})
+}
+
+func TestConsumeViewTakesOnlyTheViewsAllowed(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		snippet := newSnippet(t, repos, owner, func(s *models.Snippet) { s.MaxViews = 2 })
+
+		for want := 1; want <= 2; want++ {
+			consumed, err := repos.Snippets.ConsumeView(ctx, snippet.ID, time.Now())
+			if err != nil {
+				t.Fatalf("view %d: %v", want, err)
+			}
+			if consumed.CurrentViews != want {
+				t.Fatalf("view %d: got %d views", want, consumed.CurrentViews)
+			}
+		}
+		if _, err := repos.Snippets.ConsumeView(ctx, snippet.ID, time.Now()); !errors.Is(err, repository.ErrBurnt) {
+			t.Fatalf("third view: got %v, want ErrBurnt", err)
+		}
+
+		expired := newSnippet(t, repos, owner, func(s *models.Snippet) { s.ExpiresAt = time.Now().Add(-time.Minute) })
+		if _, err := repos.Snippets.ConsumeView(ctx, expired.ID, time.Now()); !errors.Is(err, repository.ErrExpired) {
+			t.Fatalf("expired: got %v, want ErrExpired", err)
+		}
+		if _, err := repos.Snippets.ConsumeView(ctx, uuid.New(), time.Now()); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("unknown: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestConcurrentConsumeViewNeverOvershoots(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		snippet := newSnippet(t, repos, owner, func(s *models.Snippet) { s.MaxViews = 5 })
+
+		var wg sync.WaitGroup
+		var taken atomic.Int32
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repos.Snippets.ConsumeView(ctx, snippet.ID, time.Now()); err == nil {
+					taken.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		stored, err := repos.Snippets.Get(ctx, snippet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken.Load() != 5 || stored.CurrentViews != 5 {
+			t.Fatalf("got %d views taken and %d stored, want 5", taken.Load(), stored.CurrentViews)
+		}
+	})
+}
+
+func TestRecordFailedAttemptBurnsOnTheLastOne(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		snippet := newSnippet(t, repos, owner, func(s *models.Snippet) {
+			s.MaxViews = 3
+			s.PassphraseProtected = true
+			s.MaxAttempts = 2
+		})
+
+		counted, err := repos.Snippets.RecordFailedAttempt(ctx, snippet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counted.FailedAttempts != 1 || counted.CurrentViews != 0 {
+			t.Fatalf("first miss: got %d attempts and %d views", counted.FailedAttempts, counted.CurrentViews)
+		}
+
+		counted, err = repos.Snippets.RecordFailedAttempt(ctx, snippet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counted.FailedAttempts != 2 || counted.CurrentViews != counted.MaxViews {
+			t.Fatalf("last miss: got %d attempts and %d of %d views", counted.FailedAttempts, counted.CurrentViews, counted.MaxViews)
+		}
+		if _, err := repos.Snippets.ConsumeView(ctx, snippet.ID, time.Now()); !errors.Is(err, repository.ErrBurnt) {
+			t.Fatalf("view after the last miss: got %v, want ErrBurnt", err)
+		}
+
+		if _, err := repos.Snippets.RecordFailedAttempt(ctx, uuid.New()); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("unknown: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestCountLiveLinks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		snippet := newSnippet(t, repos, owner, nil)
+
+		newLink := func(maxViews int, expiresAt time.Time) *models.SnippetLink {
+			t.Helper()
+			link := &models.SnippetLink{SnippetID: snippet.ID, MaxViews: maxViews, ExpiresAt: expiresAt}
+			if err := repos.Links.Create(ctx, link); err != nil {
+				t.Fatal(err)
+			}
+			return link
+		}
+		later := time.Now().Add(time.Hour)
+
+		live := newLink(2, later)
+		spent := newLink(1, later)
+		revoked := newLink(1, later)
+		newLink(1, time.Now().Add(-time.Minute))
+
+		if err := repos.Links.ConsumeView(ctx, spent.ID, &models.SnippetLinkView{IP: "203.0.113.7", ViewedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Links.ConsumeView(ctx, live.ID, &models.SnippetLinkView{IP: "203.0.113.8", ViewedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Links.Revoke(ctx, revoked.ID, snippet.ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := repos.Links.CountLive(ctx, snippet.ID, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("got %d live links, want 1", count)
+		}
+		views, err := repos.Links.SumViews(ctx, snippet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if views != 2 {
+			t.Fatalf("got %d link views, want 2", views)
+		}
+
+		for name, tt := range map[string]struct {
+			id   uuid.UUID
+			want error
+		}{
+			"spent":   {spent.ID, repository.ErrBurnt},
+			"revoked": {revoked.ID, repository.ErrBurnt},
+			"unknown": {uuid.New(), repository.ErrNotFound},
+		} {
+			if err := repos.Links.ConsumeView(ctx, tt.id, &models.SnippetLinkView{ViewedAt: time.Now()}); !errors.Is(err, tt.want) {
+				t.Fatalf("%s link: got %v, want %v", name, err, tt.want)
+			}
+		}
+		if err := repos.Links.Revoke(ctx, revoked.ID, snippet.ID, time.Now()); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("revoking twice: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestListPurgeable(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		now := time.Now()
+
+		expiredFirst := newSnippet(t, repos, owner, func(s *models.Snippet) { s.ExpiresAt = now.Add(-2 * time.Hour) })
+		expiredLater := newSnippet(t, repos, owner, func(s *models.Snippet) { s.ExpiresAt = now.Add(-time.Hour) })
+		burntLongAgo := newSnippet(t, repos, owner, nil)
+		burntJustNow := newSnippet(t, repos, owner, nil)
+		newSnippet(t, repos, owner, nil)
+
+		if err := repos.Snippets.Purge(ctx, burntLongAgo.ID, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Snippets.Purge(ctx, burntJustNow.ID, now); err != nil {
+			t.Fatal(err)
+		}
+
+		filter := repository.PurgeFilter{ExpiredBefore: now, BurntBefore: now.Add(-time.Minute), Limit: 10}
+		purgeable, err := repos.Snippets.ListPurgeable(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uuid.UUID{expiredFirst.ID, expiredLater.ID, burntLongAgo.ID}
+		if got := snippetIDs(purgeable); !slices.Equal(got, want) {
+			t.Fatalf("got %v, want %v oldest expiry first", got, want)
+		}
+
+		filter.Skip = []uuid.UUID{expiredFirst.ID}
+		filter.Limit = 1
+		purgeable, err = repos.Snippets.ListPurgeable(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := snippetIDs(purgeable); !slices.Equal(got, []uuid.UUID{expiredLater.ID}) {
+			t.Fatalf("skipping and limiting: got %v", got)
+		}
+
+		stored, err := repos.Snippets.Get(ctx, burntJustNow.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Content != "" || stored.BurntAt == nil {
+			t.Fatalf("purged snippet kept content %q, burnt_at %v", stored.Content, stored.BurntAt)
+		}
+	})
+}
+
+func TestFailedTransactionRollsBack(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		ctx := context.Background()
+		owner := newUser(t, repos, "owner@example.com")
+		snippet := newSnippet(t, repos, owner, nil)
+
+		failure := errors.New("rolled back")
+		err := repos.Transaction(ctx, func(ctx context.Context) error {
+			if _, err := repos.Snippets.ConsumeView(ctx, snippet.ID, time.Now()); err != nil {
+				return err
+			}
+			if err := repos.Snippets.Purge(ctx, snippet.ID, time.Now()); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("got %v, want the error of fn", err)
+		}
+
+		stored, err := repos.Snippets.Get(ctx, snippet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.CurrentViews != 0 || stored.BurntAt != nil || stored.Content == "" {
+			t.Fatalf("rolled back transaction left %d views, burnt_at %v, content %q", stored.CurrentViews, stored.BurntAt, stored.Content)
+		}
+	})
+}
+
+func snippetIDs(snippets []models.Snippet) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(snippets))
+	for _, snippet := range snippets {
+		ids = append(ids, snippet.ID)
+	}
+	return ids
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// MaxAPITokensPerUser caps the active (not revoked) tokens of one account
+const MaxAPITokensPerUser = 20
+
+// Scopes an API token may be granted
+var APITokenScopes = map[string]bool{
+	models.ScopeSnippetsCreate:  true,
+	models.ScopeSnippetsReadOwn: true,
+	models.ScopeSnippetsDelete:  true,
+	models.ScopeLinksManage:     true,
+	models.ScopeWebhooksManage:  true,
+	models.ScopeDashboardRead:   true,
+}
+
+// How often last_used_at is written for a busy token
+const apiTokenUsageResolution = time.Minute
+
+type APITokenService struct {
+	db *gorm.DB
+}
+
+func NewAPITokenService(db *gorm.DB) *APITokenService {
+	return &APITokenService{
+		db: db,
+	}
+}
+
+type CreateAPITokenInput struct {
+	Name   string
+	Scopes []string
+	// ExpiresInDays of 0 creates a token that never expires
+	ExpiresInDays int
+}
+
+// CreateToken mints a token and returns it in plain text. That is the only time
+// it is ever shown, only its hash is stored.
+func (s *APITokenService) CreateToken(ctx context.Context, userID uuid.UUID, input CreateAPITokenInput) (*models.APIToken, string, error) {
+	scopes := make([]string, 0, len(input.Scopes))
+	seen := map[string]bool{}
+	for _, scope := range input.Scopes {
+		if !APITokenScopes[scope] {
+			return nil, "", invalidInput("unknown scope: " + scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "", invalidInput("no scopes")
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).
+		Model(&models.APIToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count >= MaxAPITokensPerUser {
+		return nil, "", ErrTooManyAPITokens
+	}
+
+	plain, hash, err := utils.GenerateAPIToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    plain[:len(utils.APITokenPrefix)+6],
+		TokenHash: hash,
+		Scopes:    strings.Join(scopes, ","),
+	}
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.WithContext(ctx).Create(token).Error; err != nil {
+		return nil, "", err
+	}
+
+	return token, plain, nil
+}
+
+type APITokenOverview struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (s *APITokenService) ListTokens(ctx context.Context, userID uuid.UUID) ([]APITokenOverview, error) {
+	var tokens []models.APIToken
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	overviews := make([]APITokenOverview, 0, len(tokens))
+	for _, token := range tokens {
+		overviews = append(overviews, APITokenOverview{
+			ID:         token.ID,
+			Name:       token.Name,
+			Prefix:     token.Prefix,
+			Scopes:     strings.Split(token.Scopes, ","),
+			ExpiresAt:  token.ExpiresAt,
+			LastUsedAt: token.LastUsedAt,
+			RevokedAt:  token.RevokedAt,
+			CreatedAt:  token.CreatedAt,
+		})
+	}
+
+	return overviews, nil
+}
+
+// RevokeToken disables a token right away. The row is kept so the list still shows it.
+func (s *APITokenService) RevokeToken(ctx context.Context, userID, tokenID uuid.UUID) error {
+	result := s.db.WithContext(ctx).
+		Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// VerifyAPIToken resolves a bearer API token to its user and scopes
+func (s *APITokenService) VerifyAPIToken(ctx context.Context, plain string) (uuid.UUID, []string, error) {
+	var token models.APIToken
+	if err := s.db.WithContext(ctx).
+		Select("id", "user_id", "scopes", "expires_at", "last_used_at", "revoked_at").
+		Where("token_hash = ?", utils.HashToken(plain)).
+		First(&token).Error; err != nil {
+		return uuid.Nil, nil, ErrInvalidAPIToken
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return uuid.Nil, nil, ErrAPITokenRevoked
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return uuid.Nil, nil, ErrAPITokenExpired
+	}
+
+	// Record usage, at most once per resolution so busy pipelines don't write on every call.
+	// It is informational only, a failed write doesn't refuse the token.
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenUsageResolution {
+		if err := s.db.WithContext(ctx).
+			Model(&models.APIToken{}).
+			Where("id = ?", token.ID).
+			Update("last_used_at", now).Error; err != nil {
+			slog.WarnContext(ctx, "Failed to record usage of api token", "api_token_id", token.ID, "error", err)
+		}
+	}
+
+	return token.UserID, strings.Split(token.Scopes, ","), nil
+}
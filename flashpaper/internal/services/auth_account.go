@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/mail"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// ResendVerification mails a new verification link, the previous one stops working
+func (s *AuthService) ResendVerification(ctx context.Context, userID uuid.UUID) error {
+	var user models.User
+	if err := s.db.WithContext(ctx).Select("id", "email", "email_verified_at").First(&user, userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+
+	return s.sendVerificationEmail(ctx, &user)
+}
+
+// VerifyEmail redeems a verification token
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		userToken, err := redeemUserToken(tx, token, models.PurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", userToken.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+}
+
+// ForgotPassword mails a reset link when the email belongs to an account. It
+// answers the same way, and just as fast, whether or not it does, so it can't
+// be used to find out who has an account.
+func (s *AuthService) ForgotPassword(ctx context.Context, email string) {
+	// Outlive the request but keep its values, the request ID included
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		var user models.User
+		if err := s.db.WithContext(ctx).Select("id", "email").Where("email = ?", email).First(&user).Error; err != nil {
+			return
+		}
+
+		token, err := issueUserToken(s.db.WithContext(ctx), user.ID, models.PurposeResetPassword, s.config.PasswordResetTTL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create password reset token", "user_id", user.ID, "error", err)
+			return
+		}
+
+		link := s.config.ClientURL + "/auth/reset-password?token=" + url.QueryEscape(token)
+		if err := s.mailer.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: "Reset your FlashPaper password",
+			Text: fmt.Sprintf("Someone asked to reset the password of your FlashPaper account.\n\n"+
+				"Choose a new password here:\n%s\n\n"+
+				"The link works once and expires soon. If this wasn't you, ignore this email, your password stays unchanged.\n", link),
+		}); err != nil {
+			slog.ErrorContext(ctx, "Failed to send password reset email", "user_id", user.ID, "error", err)
+		}
+	}()
+}
+
+// ResetPassword redeems a reset token. Every session of the account is signed out,
+// whoever knew the old password is locked out right away.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		userToken, err := redeemUserToken(tx, token, models.PurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		// Following the link proves the mailbox, so the email counts as verified too.
+		// It also lifts a lockout, the owner is back in control.
+		if err := tx.Model(&models.User{}).Where("id = ?", userToken.UserID).Updates(map[string]interface{}{
+			"password":          string(hashedPassword),
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+			"failed_logins":     0,
+			"locked_until":      nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		return revokeFamilies(tx, "user_id = ?", userToken.UserID)
+	})
+}
+
+// ChangePassword replaces the password of a signed in user. All sessions are
+// signed out and the caller gets a fresh token pair to stay logged in.
+func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string) (*TokenPair, error) {
+	var tokens *TokenPair
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id", "password").
+			First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+			return ErrWrongPassword
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+
+		if err := revokeFamilies(tx, "user_id = ?", user.ID); err != nil {
+			return err
+		}
+
+		tokens, err = issueTokens(tx, user.ID, uuid.New())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *AuthService) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := issueUserToken(s.db.WithContext(ctx), user.ID, models.PurposeVerifyEmail, s.config.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.config.ClientURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your FlashPaper email",
+		Text: fmt.Sprintf("Welcome to FlashPaper!\n\n"+
+			"Confirm your email address here:\n%s\n\n"+
+			"If you didn't create an account, ignore this email.\n", link),
+	})
+}
+
+// issueUserToken creates a mailed token for purpose. Older unused tokens of the
+// same purpose are marked used, only the latest link works.
+func issueUserToken(db *gorm.DB, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// redeemUserToken spends a mailed token of the given purpose inside tx
+func redeemUserToken(tx *gorm.DB, token, purpose string) (*models.UserToken, error) {
+	var userToken models.UserToken
+	if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("token_hash = ? AND purpose = ?", utils.HashToken(token), purpose).
+		First(&userToken).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	if err := tx.Model(&userToken).Update("used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	return &userToken, nil
+}
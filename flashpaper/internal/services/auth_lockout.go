@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/direwen/flashpaper/internal/models"
+)
+
+const (
+	// LockoutThreshold wrong passwords in a row lock the account
+	LockoutThreshold = 5
+	// The first lock lasts lockoutBase, every further miss doubles it up to lockoutMax
+	lockoutBase = time.Minute
+	lockoutMax  = time.Hour
+)
+
+// AccountLockedError is returned by LoginUser and VerifyMFA while an account is locked
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return "account_locked"
+}
+
+// recordFailedLogin counts a wrong password or second factor and locks the account
+// once the threshold is reached. It returns the lock error when this miss caused one.
+func (s *AuthService) recordFailedLogin(ctx context.Context, user *models.User) error {
+	failures, err := s.repos.Users.RecordFailedLogin(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	duration := lockoutDuration(failures)
+	if duration == 0 {
+		return nil
+	}
+
+	until := time.Now().Add(duration)
+	if err := s.repos.Users.Lock(ctx, user.ID, until); err != nil {
+		return err
+	}
+
+	return &AccountLockedError{Until: until}
+}
+
+// resetFailedLogins forgets the misses of a user who completed a login
+func (s *AuthService) resetFailedLogins(ctx context.Context, user *models.User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return s.repos.Users.ResetFailedLogins(ctx, user.ID)
+}
+
+// lockoutDuration is how long an account is locked after the given number of misses
+func lockoutDuration(failures int) time.Duration {
+	if failures < LockoutThreshold {
+		return 0
+	}
+
+	duration := lockoutBase
+	for i := LockoutThreshold; i < failures && duration < lockoutMax; i++ {
+		duration *= 2
+	}
+
+	return min(duration, lockoutMax)
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/metrics"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes an account holds at a time
+	RecoveryCodeCount = 10
+	// MaxMFAAttempts wrong codes end a login challenge, the password has to be entered again
+	MaxMFAAttempts = 5
+	totpIssuer     = "FlashPaper"
+)
+
+// LoginResult is either a token pair or, for two-factor accounts, an MFA challenge
+// that has to be completed with VerifyMFA
+type LoginResult struct {
+	Tokens       *TokenPair
+	MFAToken     string
+	MFAExpiresAt time.Time
+}
+
+func (s *AuthService) createMFAChallenge(ctx context.Context, userID uuid.UUID) (*LoginResult, error) {
+	token, hash, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &models.MFAChallenge{
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.config.MFATokenTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(challenge).Error; err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{MFAToken: token, MFAExpiresAt: challenge.ExpiresAt}, nil
+}
+
+// VerifyMFA completes a login challenge with a TOTP code or a recovery code.
+// A challenge is single use and dies after MaxMFAAttempts wrong codes. Every wrong
+// code also counts towards the account lockout, like a wrong password.
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken, code, recoveryCode string) (_ *TokenPair, err error) {
+	defer func() { observeLogin("mfa", false, err) }()
+
+	var tokens *TokenPair
+	var failure error
+
+	// The lockout counter goes through the repositories, so it commits with the challenge
+	err = s.repos.Transaction(ctx, func(ctx context.Context) error {
+		tx := repository.Conn(ctx, s.db)
+		locking := clause.Locking{Strength: clause.LockingStrengthUpdate}
+
+		var challenge models.MFAChallenge
+		if err := tx.Clauses(locking).
+			Where("token_hash = ?", utils.HashToken(mfaToken)).
+			First(&challenge).Error; err != nil {
+			return ErrInvalidMFAToken
+		}
+		if time.Now().After(challenge.ExpiresAt) {
+			return ErrInvalidMFAToken
+		}
+
+		var user models.User
+		if err := tx.Clauses(locking).First(&user, challenge.UserID).Error; err != nil {
+			return ErrInvalidMFAToken
+		}
+
+		// Locked while the challenge was open, most likely by codes guessed on other challenges
+		if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+			return &AccountLockedError{Until: *user.LockedUntil}
+		}
+
+		if err := checkSecondFactor(tx, &user, code, recoveryCode); err != nil {
+			if !errors.Is(err, ErrInvalidMFACode) {
+				return err
+			}
+
+			// Count the miss and commit it, the error is returned after the commit.
+			// Fresh challenges don't reset the account's counter, so guessing locks it.
+			failure = err
+			if lockErr := s.recordFailedLogin(ctx, &user); lockErr != nil {
+				var locked *AccountLockedError
+				if !errors.As(lockErr, &locked) {
+					return lockErr
+				}
+				failure = lockErr
+				return tx.Delete(&challenge).Error
+			}
+
+			challenge.Attempts++
+			if challenge.Attempts >= MaxMFAAttempts {
+				failure = ErrMFAAttemptsExceeded
+				return tx.Delete(&challenge).Error
+			}
+			return tx.Model(&challenge).Update("attempts", challenge.Attempts).Error
+		}
+
+		if err := tx.Delete(&challenge).Error; err != nil {
+			return err
+		}
+		if err := s.resetFailedLogins(ctx, &user); err != nil {
+			return err
+		}
+
+		var err error
+		tokens, err = issueTokens(tx, user.ID, uuid.New())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failure != nil {
+		return nil, failure
+	}
+
+	return tokens, nil
+}
+
+// SetupTOTP starts enrollment with a fresh secret. Two-factor auth is only
+// switched on by EnableTOTP, once the user proved their app produces valid codes.
+func (s *AuthService) SetupTOTP(ctx context.Context, userID uuid.UUID) (string, string, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).Select("id", "email", "totp_enabled").First(&user, userID).Error; err != nil {
+		return "", "", ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := utils.Encrypt(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.db.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    encrypted,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", err
+	}
+
+	return secret, utils.TOTPURI(totpIssuer, user.Email, secret), nil
+}
+
+// EnableTOTP confirms enrollment with a code from the app and returns the recovery codes
+func (s *AuthService) EnableTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if user.TOTPEnabled {
+			return ErrMFAAlreadyEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrMFANotSetUp
+		}
+
+		if err := checkSecondFactor(tx, &user, code, ""); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor auth off. It takes the password and a second factor,
+// so neither a stolen session nor a stolen password alone can do it.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID uuid.UUID, password, code, recoveryCode string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if !user.TOTPEnabled {
+			return ErrMFANotEnabled
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			return ErrWrongPassword
+		}
+
+		if err := checkSecondFactor(tx, &user, code, recoveryCode); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, it needs a code from the app
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if !user.TOTPEnabled {
+			return ErrMFANotEnabled
+		}
+
+		if err := checkSecondFactor(tx, &user, code, ""); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// checkSecondFactor verifies a TOTP code, or else a recovery code, of a user locked
+// in tx and spends it: the code's time step and the recovery code can't be used again.
+func checkSecondFactor(tx *gorm.DB, user *models.User, code, recoveryCode string) error {
+	switch {
+	case code != "":
+		secret, err := utils.Decrypt(user.TOTPSecret)
+		if err != nil {
+			metrics.DecryptionFailures.WithLabelValues("totp_secret").Inc()
+			return ErrDecryptionFailed
+		}
+
+		step, ok := utils.VerifyTOTP(secret, code, time.Now())
+		if !ok || step <= user.TOTPLastStep {
+			return ErrInvalidMFACode
+		}
+
+		user.TOTPLastStep = step
+		return tx.Model(user).Update("totp_last_step", step).Error
+
+	case recoveryCode != "":
+		result := tx.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		if err := result.Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+
+	default:
+		return ErrMFACodeRequired
+	}
+}
+
+// replaceRecoveryCodes drops the user's recovery codes and returns a new set
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(utils.NormalizeRecoveryCode(code)),
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
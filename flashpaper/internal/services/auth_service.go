@@ -3,32 +3,50 @@ package services
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/direwen/flashpaper/internal/mail"
 	"github.com/direwen/flashpaper/internal/models"
-	"github.com/direwen/flashpaper/pkg/utils"
+	"github.com/direwen/flashpaper/internal/repository"
 	"github.com/google/uuid"
 )
 
 type AuthService struct {
-	db *gorm.DB
+	db     *gorm.DB // Sessions, second factors and mailed tokens
+	repos  repository.Repositories
+	mailer mail.Mailer
+	config AuthConfig
 }
 
-func NewAuthService(db *gorm.DB) *AuthService {
+// AuthConfig holds the settings of the auth service
+type AuthConfig struct {
+	// ClientURL is where links in emails point to
+	ClientURL            string
+	MFATokenTTL          time.Duration
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+}
+
+func NewAuthService(db *gorm.DB, repos repository.Repositories, mailer mail.Mailer, config AuthConfig) *AuthService {
+	config.ClientURL = strings.TrimRight(config.ClientURL, "/")
 	return &AuthService{
-		db: db,
+		db:     db,
+		repos:  repos,
+		mailer: mailer,
+		config: config,
 	}
 }
 
 func (s *AuthService) RegisterUser(ctx context.Context, email, password string) error {
 
 	//Checking if there's already a user with these credentials
-	var existingUser models.User
-	if err := s.db.WithContext(ctx).Where("email = ?", email).First(&existingUser).Error; err == nil {
-		return errors.New("user with this email already exists")
+	if _, err := s.repos.Users.GetByEmail(ctx, email); err == nil {
+		return ErrEmailTaken
 	}
 
 	//Hash the password
@@ -43,55 +61,85 @@ func (s *AuthService) RegisterUser(ctx context.Context, email, password string)
 		Password: string(hashedPassword),
 	}
 
-	if err := s.db.WithContext(ctx).Create(&user).Error; err != nil {
+	if err := s.repos.Users.Create(ctx, &user); err != nil {
 		return err
 	}
 
+	// The account works right away, a failed mail can be resent later
+	if err := s.sendVerificationEmail(ctx, &user); err != nil {
+		slog.ErrorContext(ctx, "Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+
 	return nil
 
 }
 
-func (s *AuthService) LoginUser(ctx context.Context, email, password string) (string, error) {
-	var user models.User
+func (s *AuthService) LoginUser(ctx context.Context, email, password string) (result *LoginResult, err error) {
+	defer func() { observeLogin("password", result != nil && result.MFAToken != "", err) }()
+
+	// Find the user record with all data included in it
+	user, err := s.repos.Users.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
 
-	// Find the user record and populate "user" variable with all data included in that found record
-	if err := s.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
-		return "", errors.New("invalid credentials")
+	// A locked account doesn't even get its password checked, guessing is pointless
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, &AccountLockedError{Until: *user.LockedUntil}
 	}
 
 	// Compared hashed user password and the provided password
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		if lockErr := s.recordFailedLogin(ctx, user); lockErr != nil {
+			return nil, lockErr
+		}
+		return nil, ErrInvalidCredentials
 	}
 
-	// Generate jwt token
-	token, err := utils.GenerateToken(user.ID)
+	// Two-factor accounts get a challenge instead of tokens. Their misses are
+	// only forgotten once VerifyMFA accepts the second factor as well.
+	if user.TOTPEnabled {
+		return s.createMFAChallenge(ctx, user.ID)
+	}
+
+	if err := s.resetFailedLogins(ctx, user); err != nil {
+		return nil, err
+	}
+
+	// Every login starts a new refresh token family
+	tokens, err := issueTokens(s.db.WithContext(ctx), user.ID, uuid.New())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return token, nil
+	return &LoginResult{Tokens: tokens}, nil
 
 }
 
 type UserResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 func (s *AuthService) GetUser(ctx context.Context, userID uuid.UUID) (*UserResponse, error) {
-	var user models.User
-
-	if err := s.db.WithContext(ctx).Where("id = ?", userID).First(&user).Error; err != nil {
-		return nil, errors.New("user not found")
+	user, err := s.repos.Users.Get(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return &UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		MFAEnabled:    user.TOTPEnabled,
+		CreatedAt:     user.CreatedAt,
 	}, nil
 
 }
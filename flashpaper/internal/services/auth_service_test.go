@@ -0,0 +1,65 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direwen/flashpaper/internal/mail"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+func TestWrongSecondFactorsLockTheAccount(t *testing.T) {
+	if err := utils.LoadKeyring("", "", "0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	db := newSQLiteDB(t)
+	mailer, err := mail.NewLogMailer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := services.NewAuthService(db, repository.NewGorm(db), mailer, services.AuthConfig{MFATokenTTL: time.Minute})
+	ctx := context.Background()
+
+	const email, password = "owner@example.com", "correct horse battery"
+	if err := s.RegisterUser(ctx, email, password); err != nil {
+		t.Fatal(err)
+	}
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.SetupTOTP(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&user).Update("totp_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The password is right every time, only the second factor is guessed, each
+	// guess on a fresh challenge so the per-challenge limit never kicks in
+	for attempt := 1; attempt <= services.LockoutThreshold; attempt++ {
+		result, err := s.LoginUser(ctx, email, password)
+		if err != nil {
+			t.Fatalf("login %d: %v", attempt, err)
+		}
+
+		_, err = s.VerifyMFA(ctx, result.MFAToken, "", "not-a-recovery-code")
+		var locked *services.AccountLockedError
+		switch {
+		case attempt < services.LockoutThreshold && !errors.Is(err, services.ErrInvalidMFACode):
+			t.Fatalf("guess %d: got %v, want ErrInvalidMFACode", attempt, err)
+		case attempt == services.LockoutThreshold && !errors.As(err, &locked):
+			t.Fatalf("guess %d: got %v, want the account locked", attempt, err)
+		}
+	}
+
+	var locked *services.AccountLockedError
+	if _, err := s.LoginUser(ctx, email, password); !errors.As(err, &locked) {
+		t.Fatalf("login after the lock: got %v, want AccountLockedError", err)
+	}
+}
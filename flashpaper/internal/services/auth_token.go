@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// TokenPair is what a login or refresh hands out
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// issueTokens creates an access token and a refresh token of the given family
+func issueTokens(db *gorm.DB, userID, familyID uuid.UUID) (*TokenPair, error) {
+	accessToken, access, err := utils.GenerateToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshHash, refreshExpiresAt, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Create(&models.RefreshToken{
+		UserID:          userID,
+		FamilyID:        familyID,
+		TokenHash:       refreshHash,
+		AccessTokenID:   access.TokenID,
+		AccessExpiresAt: access.ExpiresAt,
+		ExpiresAt:       refreshExpiresAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  access.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new pair. Each refresh token works
+// once: presenting a used one again revokes its whole family, including the access
+// tokens issued with it, since either the thief or the owner holds a copy.
+func (s *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	var reused bool
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+
+		// Lock the row so two concurrent refreshes can't both rotate it
+		if err := tx.Clauses(clause.Locking{
+			Strength: clause.LockingStrengthUpdate,
+		}).Where("token_hash = ?", utils.HashToken(refreshToken)).First(&token).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if token.UsedAt != nil {
+			reused = true
+			return revokeFamilies(tx, "family_id = ?", token.FamilyID)
+		}
+
+		if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = issueTokens(tx, token.UserID, token.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The revocation above has to commit, so the error is only raised afterwards
+	if reused {
+		slog.WarnContext(ctx, "Refresh token reuse detected, revoked its token family")
+		return nil, ErrRefreshTokenReused
+	}
+
+	return pair, nil
+}
+
+// Logout revokes the access token of the current request and, when given, the
+// refresh token family it belongs to. all signs the user out of every session.
+func (s *AuthService) Logout(ctx context.Context, access *utils.AccessClaims, refreshToken string, all bool) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := denylist(tx, []models.RevokedToken{{
+			TokenID:   access.TokenID,
+			UserID:    access.UserID,
+			ExpiresAt: access.ExpiresAt,
+		}}); err != nil {
+			return err
+		}
+
+		if all {
+			return revokeFamilies(tx, "user_id = ?", access.UserID)
+		}
+
+		if refreshToken == "" {
+			return nil
+		}
+
+		var token models.RefreshToken
+		if err := tx.Select("family_id").
+			Where("token_hash = ? AND user_id = ?", utils.HashToken(refreshToken), access.UserID).
+			First(&token).Error; err != nil {
+			// Unknown or foreign refresh tokens are ignored, the access token is revoked either way
+			return nil
+		}
+
+		return revokeFamilies(tx, "family_id = ?", token.FamilyID)
+	})
+}
+
+// IsTokenRevoked reports whether an access token was revoked before its expiry
+func (s *AuthService) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).
+		Model(&models.RevokedToken{}).
+		Where("token_id = ?", tokenID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// revokeFamilies revokes the refresh tokens matching the condition and denylists
+// the still valid access tokens that were issued with them
+func revokeFamilies(tx *gorm.DB, condition string, value interface{}) error {
+	now := time.Now()
+
+	var tokens []models.RefreshToken
+	if err := tx.Select("user_id", "access_token_id", "access_expires_at").
+		Where(condition, value).
+		Where("access_expires_at > ?", now).
+		Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	revoked := make([]models.RevokedToken, 0, len(tokens))
+	for _, token := range tokens {
+		revoked = append(revoked, models.RevokedToken{
+			TokenID:   token.AccessTokenID,
+			UserID:    token.UserID,
+			ExpiresAt: token.AccessExpiresAt,
+		})
+	}
+	if err := denylist(tx, revoked); err != nil {
+		return err
+	}
+
+	return tx.Model(&models.RefreshToken{}).
+		Where(condition, value).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", now).Error
+}
+
+func denylist(tx *gorm.DB, tokens []models.RevokedToken) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// Revoking twice is fine
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tokens).Error
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/direwen/flashpaper/internal/repository"
+)
+
+// Errors returned by the services. Callers compare them with errors.Is, the
+// handlers turn them into HTTP statuses and error codes in one place.
+var (
+	// Lookups of snippets, links, webhooks and tokens. These are the repository
+	// errors themselves, so they pass through the services unchanged.
+	ErrNotFound = repository.ErrNotFound
+	ErrExpired  = repository.ErrExpired
+	ErrBurnt    = repository.ErrBurnt
+	ErrRevoked  = errors.New("revoked")
+
+	// Reveals
+	ErrPassphraseRequired = errors.New("passphrase_required")
+	ErrWrongPassphrase    = errors.New("wrong_passphrase")
+	ErrLoginRequired      = errors.New("login_required")
+	ErrForbidden          = errors.New("forbidden")
+	ErrDecryptionFailed   = errors.New("decryption_failed")
+
+	// Accounts and sessions
+	ErrUserNotFound       = errors.New("user_not_found")
+	ErrEmailTaken         = errors.New("email_taken")
+	ErrInvalidCredentials = errors.New("invalid_credentials")
+	// The password confirming a change of a signed in account is wrong. Unlike
+	// ErrInvalidCredentials the session itself is fine.
+	ErrWrongPassword       = errors.New("wrong_password")
+	ErrInvalidToken        = errors.New("invalid_token")
+	ErrAlreadyVerified     = errors.New("already_verified")
+	ErrInvalidRefreshToken = errors.New("invalid_refresh_token")
+	ErrRefreshTokenReused  = errors.New("refresh_token_reused")
+
+	// Two-factor authentication
+	ErrInvalidMFAToken     = errors.New("invalid_mfa_token")
+	ErrInvalidMFACode      = errors.New("invalid_mfa_code")
+	ErrMFACodeRequired     = errors.New("mfa_code_required")
+	ErrMFAAttemptsExceeded = errors.New("mfa_attempts_exceeded")
+	ErrMFAAlreadyEnabled   = errors.New("mfa_already_enabled")
+	ErrMFANotSetUp         = errors.New("mfa_not_set_up")
+	ErrMFANotEnabled       = errors.New("mfa_not_enabled")
+
+	// API tokens
+	ErrInvalidAPIToken = errors.New("invalid_api_token")
+	ErrAPITokenRevoked = errors.New("api_token_revoked")
+	ErrAPITokenExpired = errors.New("api_token_expired")
+
+	// Per-account caps
+	ErrTooManyAPITokens = errors.New("too_many_api_tokens")
+	ErrTooManyWebhooks  = errors.New("too_many_webhooks")
+)
+
+// ValidationError is input a service refuses. Its message is meant for the caller.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalidInput(message string) error {
+	return &ValidationError{Message: message}
+}
+
+// notFound turns a missing row into ErrNotFound and lets real failures through
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// Readiness check results
+const (
+	CheckOK      = "ok"
+	CheckFailing = "failing"
+	CheckStale   = "stale"
+	CheckPending = "pending"
+	CheckStandby = "standby"
+)
+
+// healthSelfTestText is encrypted and decrypted with the active key on every readiness probe
+const healthSelfTestText = "flashpaper readiness self-test"
+
+type HealthConfig struct {
+	// PingTimeout bounds the database ping of a readiness probe
+	PingTimeout time.Duration
+	// JanitorInterval is how often the janitor should succeed, older runs are reported stale
+	JanitorInterval time.Duration
+	// JanitorLastSuccess returns the end of the janitor's last clean run, zero before the first
+	JanitorLastSuccess func() time.Time
+	// JanitorStandby reports whether another instance runs the janitor, whose health this one can't see
+	JanitorStandby func() bool
+}
+
+type HealthService struct {
+	db     *sql.DB
+	config HealthConfig
+	// draining is set once shutdown starts, load balancers then stop routing to us
+	draining atomic.Bool
+}
+
+func NewHealthService(db *sql.DB, config HealthConfig) *HealthService {
+	return &HealthService{
+		db:     db,
+		config: config,
+	}
+}
+
+// CheckResult is the outcome of one readiness check. Errors are logged, never
+// returned, the probes are public.
+type CheckResult struct {
+	Status    string   `json:"status"`
+	LatencyMS *int64   `json:"latency_ms,omitempty"`
+	AgeSecs   *float64 `json:"age_seconds,omitempty"`
+}
+
+type Readiness struct {
+	Ready    bool                   `json:"ready"`
+	Draining bool                   `json:"draining"`
+	Checks   map[string]CheckResult `json:"checks"`
+}
+
+// Drain marks the instance as not ready, called before the server shuts down
+func (s *HealthService) Drain() {
+	s.draining.Store(true)
+}
+
+// Readiness runs the dependency checks. The database and the keyring must be
+// healthy; the janitor is only reported, a stuck janitor doesn't stop reveals.
+func (s *HealthService) Readiness(ctx context.Context) Readiness {
+	database := s.checkDatabase(ctx)
+	encryption := s.checkEncryption()
+	draining := s.draining.Load()
+
+	return Readiness{
+		Ready:    !draining && database.Status == CheckOK && encryption.Status == CheckOK,
+		Draining: draining,
+		Checks: map[string]CheckResult{
+			"database":   database,
+			"encryption": encryption,
+			"janitor":    s.checkJanitor(),
+		},
+	}
+}
+
+func (s *HealthService) checkDatabase(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, s.config.PingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.db.PingContext(ctx)
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		slog.Warn("Readiness: database ping failed", "error", err)
+		return CheckResult{Status: CheckFailing, LatencyMS: &latency}
+	}
+	return CheckResult{Status: CheckOK, LatencyMS: &latency}
+}
+
+// checkEncryption round-trips a fixed text through the active key
+func (s *HealthService) checkEncryption() CheckResult {
+	err := func() error {
+		sealed, err := utils.Encrypt(healthSelfTestText)
+		if err != nil {
+			return err
+		}
+		opened, err := utils.Decrypt(sealed)
+		if err != nil {
+			return err
+		}
+		if opened != healthSelfTestText {
+			return errors.New("decrypted text does not match")
+		}
+		return nil
+	}()
+	if err != nil {
+		slog.Error("Readiness: encryption self-test failed", "error", err)
+		return CheckResult{Status: CheckFailing}
+	}
+	return CheckResult{Status: CheckOK}
+}
+
+func (s *HealthService) checkJanitor() CheckResult {
+	if s.config.JanitorStandby != nil && s.config.JanitorStandby() {
+		return CheckResult{Status: CheckStandby}
+	}
+
+	last := s.config.JanitorLastSuccess()
+	if last.IsZero() {
+		return CheckResult{Status: CheckPending}
+	}
+
+	age := time.Since(last).Seconds()
+	status := CheckOK
+	// A few missed runs are normal under load, more mean it keeps failing
+	if time.Since(last) > 3*s.config.JanitorInterval {
+		status = CheckStale
+	}
+	return CheckResult{Status: status, AgeSecs: &age}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/direwen/flashpaper/internal/metrics"
+	"github.com/direwen/flashpaper/internal/models"
+)
+
+// Errors a reveal is expected to fail with, their text is the reason label
+var revealFailureReasons = []error{
+	ErrNotFound, ErrExpired, ErrBurnt, ErrRevoked,
+	ErrPassphraseRequired, ErrWrongPassphrase, ErrLoginRequired, ErrForbidden,
+	ErrDecryptionFailed,
+}
+
+// observeReveal records the outcome of a reveal once its transaction is over.
+// via is "snippet" or "link", snippet is the consumed snippet on success.
+func observeReveal(via string, snippet *models.Snippet, err error) {
+	if err == nil {
+		metrics.SnippetViews.WithLabelValues(via).Inc()
+		// A link running dry leaves the snippet itself alive
+		if via == "snippet" && snippet.CurrentViews >= snippet.MaxViews {
+			metrics.SnippetBurns.WithLabelValues("max_views").Inc()
+		}
+		return
+	}
+
+	reason := "error"
+	for _, known := range revealFailureReasons {
+		if errors.Is(err, known) {
+			reason = known.Error()
+			break
+		}
+	}
+	metrics.RevealFailures.WithLabelValues(reason).Inc()
+
+	if errors.Is(err, ErrDecryptionFailed) {
+		metrics.DecryptionFailures.WithLabelValues("snippet").Inc()
+	}
+}
+
+// observeLogin records the outcome of a login step, "password" or "mfa"
+func observeLogin(step string, mfaRequired bool, err error) {
+	var locked *AccountLockedError
+	result := "success"
+	switch {
+	case err == nil && mfaRequired:
+		result = "mfa_required"
+	case err == nil:
+	case errors.As(err, &locked):
+		result = "locked"
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidMFAToken),
+		errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrMFACodeRequired), errors.Is(err, ErrMFAAttemptsExceeded):
+		result = "failure"
+	default:
+		result = "error"
+	}
+	metrics.Logins.WithLabelValues(step, result).Inc()
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/metrics"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+)
+
+// MaxAllowedUsers caps the allowlist of a restricted snippet
+const MaxAllowedUsers = 50
+
+// RevealRequest carries everything about the reader a reveal has to check
+type RevealRequest struct {
+	Passphrase string
+	// UserID is the authenticated reader, uuid.Nil for anonymous reveals
+	UserID    uuid.UUID
+	IP        string
+	UserAgent string
+}
+
+// resolveAllowedUsers turns emails or user IDs into the IDs of registered users
+func (s SnippetService) resolveAllowedUsers(ctx context.Context, entries []string) ([]uuid.UUID, error) {
+	if len(entries) > MaxAllowedUsers {
+		return nil, invalidInput("too many allowed users")
+	}
+
+	seen := map[uuid.UUID]bool{}
+	var ids []uuid.UUID
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var user *models.User
+		var err error
+		if id, parseErr := uuid.Parse(entry); parseErr == nil {
+			user, err = s.repos.Users.Get(ctx, id)
+		} else {
+			user, err = s.repos.Users.GetByEmailFold(ctx, entry)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, invalidInput("unknown user: " + entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			ids = append(ids, user.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// createWithAccess stores a snippet together with its allowlist (if any) in one transaction
+func (s SnippetService) createWithAccess(ctx context.Context, snippet *models.Snippet, allowed []uuid.UUID) error {
+	err := s.repos.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.repos.Snippets.Create(ctx, snippet); err != nil {
+			return err
+		}
+
+		if len(allowed) == 0 {
+			return nil
+		}
+		return s.repos.Allowlist.Add(ctx, snippet.ID, allowed)
+	})
+	if err != nil {
+		return err
+	}
+
+	metrics.SnippetsCreated.WithLabelValues(snippet.Kind).Inc()
+	return nil
+}
+
+// checkAccess enforces the access policy of a snippet inside a reveal transaction.
+// It runs before the passphrase check so outsiders can't burn attempts either.
+func (s SnippetService) checkAccess(ctx context.Context, snippet *models.Snippet, userID uuid.UUID) error {
+	if !snippet.Restricted {
+		return nil
+	}
+
+	if userID == uuid.Nil {
+		return ErrLoginRequired
+	}
+
+	// The owner can always read their own secret
+	if userID == snippet.UserID {
+		return nil
+	}
+
+	allowed, err := s.repos.Allowlist.Contains(ctx, snippet.ID, userID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/tracing"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// FileManifest describes an attachment. It is stored encrypted in Snippet.Content
+// because a file name alone can give away what the secret is.
+type FileManifest struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+}
+
+type CreateFileSnippetInput struct {
+	FileName         string
+	ContentType      string
+	Body             io.Reader
+	Title            string
+	AllowedUsers     []string
+	MaxViews         int
+	ExpiresInMinutes int
+}
+
+// CreateFileSnippet encrypts the body chunk by chunk while it streams into the
+// blob store, so uploads are never fully buffered in memory.
+func (s SnippetService) CreateFileSnippet(ctx context.Context, userID uuid.UUID, input CreateFileSnippetInput) (*models.Snippet, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.CreateFileSnippet")
+	defer span.End()
+
+	// Sanitize file name, never trust a client supplied path
+	name := filepath.Base(strings.TrimSpace(input.FileName))
+	if name == "." || name == string(filepath.Separator) || name == "" {
+		name = "attachment"
+	}
+	contentType := strings.TrimSpace(input.ContentType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Encrypt the manifest like any other content
+	manifest, err := json.Marshal(FileManifest{Name: name, ContentType: contentType})
+	if err != nil {
+		return nil, err
+	}
+	encryptedManifest, err := encrypt(ctx, string(manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the access policy before accepting any bytes
+	allowed, err := s.resolveAllowedUsers(ctx, input.AllowedUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypt on the fly: the stream cipher writes into a pipe the blob store reads from
+	blobKey := uuid.NewString()
+	body := &countingReader{r: input.Body}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(utils.EncryptStream(pw, body))
+	}()
+
+	if _, err := s.blobs.Put(ctx, blobKey, pr); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+
+	snippet := &models.Snippet{
+		UserID:     userID,
+		Content:    encryptedManifest,
+		Encryption: models.EncryptionServer,
+		Kind:       models.KindFile,
+		BlobKey:    blobKey,
+		FileSize:   body.n,
+		Restricted: len(allowed) > 0,
+		Title:      strings.TrimSpace(input.Title),
+		Language:   "text",
+		MaxViews:   input.MaxViews,
+		ExpiresAt:  time.Now().Add(time.Minute * time.Duration(input.ExpiresInMinutes)),
+	}
+
+	if err := s.createWithAccess(ctx, snippet, allowed); err != nil {
+		// Don't leave an orphaned blob behind
+		if delErr := s.blobs.Delete(context.Background(), blobKey); delErr != nil {
+			slog.ErrorContext(ctx, "Failed to delete orphaned blob", "blob_key", blobKey, "error", delErr)
+		}
+		return nil, err
+	}
+
+	return snippet, nil
+}
+
+// ReadFileManifest parses the manifest of a revealed file snippet
+func ReadFileManifest(snippet *models.Snippet) (*FileManifest, error) {
+	if snippet.Kind != models.KindFile {
+		return nil, errors.New("not a file snippet")
+	}
+
+	var manifest FileManifest
+	if err := json.Unmarshal([]byte(snippet.Content), &manifest); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return &manifest, nil
+}
+
+// StreamFile decrypts the blob of a revealed file snippet into w. Call it only
+// after GetSnippet has consumed the view. The download of the final view takes
+// the blob with it.
+func (s SnippetService) StreamFile(ctx context.Context, snippet *models.Snippet, w io.Writer) error {
+	ctx, span := tracing.Start(ctx, "SnippetService.StreamFile")
+	defer span.End()
+	defer s.deleteBurntBlob(ctx, snippet)
+
+	blob, err := s.blobs.Open(ctx, snippet.BlobKey)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return utils.DecryptStream(w, blob)
+}
+
+// countingReader records how many plaintext bytes were uploaded
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
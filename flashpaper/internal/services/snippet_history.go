@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/tracing"
+)
+
+// BurySnippet records the tombstone of a snippet whose content is gone, in the
+// transaction of ctx. reason is one of the models.Tombstone* values and at is
+// when the content went.
+func BurySnippet(ctx context.Context, repos repository.Repositories, snippet *models.Snippet, reason string, at time.Time) error {
+	tombstone := &models.SnippetTombstone{
+		SnippetID: snippet.ID,
+		UserID:    snippet.UserID,
+		Title:     snippet.Title,
+		Language:  snippet.Language,
+		Kind:      snippet.Kind,
+		Views:     snippet.CurrentViews,
+		MaxViews:  snippet.MaxViews,
+		Reason:    reason,
+		CreatedAt: snippet.CreatedAt,
+		EndedAt:   at,
+	}
+
+	switch reason {
+	case models.TombstoneMaxViews, models.TombstonePassphraseAttempts:
+		tombstone.BurntAt = &at
+	case models.TombstoneExpired:
+		tombstone.ExpiredAt = &snippet.ExpiresAt
+	}
+
+	linkViews, err := repos.Links.SumViews(ctx, snippet.ID)
+	if err != nil {
+		return err
+	}
+	tombstone.LinkViews = linkViews
+
+	return repos.Tombstones.Create(ctx, tombstone)
+}
+
+// BurnReason tells why a burnt snippet burnt, its last view or its last passphrase attempt
+func BurnReason(snippet *models.Snippet) string {
+	if snippet.PassphraseProtected && snippet.FailedAttempts >= snippet.MaxAttempts {
+		return models.TombstonePassphraseAttempts
+	}
+	return models.TombstoneMaxViews
+}
+
+type HistorySnippet struct {
+	ID        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	Language  string     `json:"language"`
+	Kind      string     `json:"kind"`
+	Reason    string     `json:"reason"` // max_views, passphrase_attempts, expired or deleted
+	Views     int        `json:"views"`
+	MaxViews  int        `json:"max_views"`
+	LinkViews int        `json:"link_views"`
+	CreatedAt time.Time  `json:"created_at"`
+	BurntAt   *time.Time `json:"burnt_at,omitempty"`
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	EndedAt   time.Time  `json:"ended_at"`
+}
+
+// GetSnippetHistory pages through the tombstones of the owner's snippets that
+// burnt, expired or were deleted, most recent first
+func (s SnippetService) GetSnippetHistory(ctx context.Context, userID uuid.UUID, page, limit int) ([]HistorySnippet, Page, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.GetSnippetHistory")
+	defer span.End()
+
+	p := newPage(page, limit)
+	tombstones, total, err := s.repos.Tombstones.List(ctx, userID, p.offset(), p.Limit)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	p.Total = total
+
+	history := make([]HistorySnippet, 0, len(tombstones))
+	for _, tombstone := range tombstones {
+		history = append(history, HistorySnippet{
+			ID:        tombstone.SnippetID,
+			Title:     tombstone.Title,
+			Language:  tombstone.Language,
+			Kind:      tombstone.Kind,
+			Reason:    tombstone.Reason,
+			Views:     tombstone.Views,
+			MaxViews:  tombstone.MaxViews,
+			LinkViews: tombstone.LinkViews,
+			CreatedAt: tombstone.CreatedAt,
+			BurntAt:   tombstone.BurntAt,
+			ExpiredAt: tombstone.ExpiredAt,
+			EndedAt:   tombstone.EndedAt,
+		})
+	}
+
+	return history, p, nil
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/tracing"
+)
+
+type CreateLinkInput struct {
+	Label    string
+	MaxViews int
+	// ExpiresInMinutes of 0 keeps the link alive as long as the snippet
+	ExpiresInMinutes int
+}
+
+// CreateLink adds a recipient link to one of the owner's snippets. The link can
+// never outlive the snippet itself.
+func (s SnippetService) CreateLink(ctx context.Context, userID, snippetID uuid.UUID, input CreateLinkInput) (*models.SnippetLink, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.CreateLink")
+	defer span.End()
+
+	snippet, err := s.ownedSnippet(ctx, userID, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(snippet.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	if snippet.BurntAt != nil {
+		return nil, ErrBurnt
+	}
+
+	expiresAt := snippet.ExpiresAt
+	if input.ExpiresInMinutes > 0 {
+		if custom := time.Now().Add(time.Minute * time.Duration(input.ExpiresInMinutes)); custom.Before(expiresAt) {
+			expiresAt = custom
+		}
+	}
+
+	link := &models.SnippetLink{
+		SnippetID: snippet.ID,
+		Label:     strings.TrimSpace(input.Label),
+		MaxViews:  input.MaxViews,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.repos.Links.Create(ctx, link); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// ownedSnippet loads a snippet of userID, ErrNotFound when it belongs to someone else
+func (s SnippetService) ownedSnippet(ctx context.Context, userID, snippetID uuid.UUID) (*models.Snippet, error) {
+	snippet, err := s.repos.Snippets.Get(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	if snippet.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return snippet, nil
+}
+
+type LinkView struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	ViewedAt  time.Time `json:"viewed_at"`
+}
+
+type LinkOverview struct {
+	ID           uuid.UUID  `json:"id"`
+	Label        string     `json:"label"`
+	MaxViews     int        `json:"max_views"`
+	CurrentViews int        `json:"current_views"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Views        []LinkView `json:"views"`
+}
+
+// ListLinks returns every recipient link of a snippet together with its audit trail
+func (s SnippetService) ListLinks(ctx context.Context, userID, snippetID uuid.UUID) ([]LinkOverview, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.ListLinks")
+	defer span.End()
+
+	// Make sure the snippet belongs to the caller
+	if _, err := s.ownedSnippet(ctx, userID, snippetID); err != nil {
+		return nil, err
+	}
+
+	links, err := s.repos.Links.List(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	overviews := make([]LinkOverview, 0, len(links))
+	for _, link := range links {
+		views := make([]LinkView, 0, len(link.Views))
+		for _, view := range link.Views {
+			views = append(views, LinkView{IP: view.IP, UserAgent: view.UserAgent, ViewedAt: view.ViewedAt})
+		}
+
+		overviews = append(overviews, LinkOverview{
+			ID:           link.ID,
+			Label:        link.Label,
+			MaxViews:     link.MaxViews,
+			CurrentViews: link.CurrentViews,
+			ExpiresAt:    link.ExpiresAt,
+			RevokedAt:    link.RevokedAt,
+			CreatedAt:    link.CreatedAt,
+			Views:        views,
+		})
+	}
+
+	return overviews, nil
+}
+
+// RevokeLink cuts off a single recipient. The link row is kept for the audit trail.
+func (s SnippetService) RevokeLink(ctx context.Context, userID, snippetID, linkID uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "SnippetService.RevokeLink")
+	defer span.End()
+
+	var snippet *models.Snippet
+	err := s.repos.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		snippet, err = s.ownedSnippet(ctx, userID, snippetID)
+		if err != nil {
+			return err
+		}
+		// Hold the snippet so a concurrent own view can't miss the revoke, see RevealLink
+		snippet, err = s.repos.Snippets.GetForUpdate(ctx, snippetID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.repos.Links.Revoke(ctx, linkID, snippetID, time.Now()); err != nil {
+			return err
+		}
+
+		// Revoking the last live link of a snippet without views of its own burns it
+		return s.burnIfSpent(ctx, snippet)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.deleteBurntBlob(ctx, snippet)
+	return nil
+}
+
+// GetLinkMetadata is the non-consuming landing data of a recipient link
+func (s SnippetService) GetLinkMetadata(ctx context.Context, linkID uuid.UUID) (*SnippetMetadata, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.GetLinkMetadata")
+	defer span.End()
+
+	link, err := s.repos.Links.Get(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	snippet, err := s.repos.Snippets.Get(ctx, link.SnippetID)
+	if err != nil {
+		return nil, err
+	}
+	link.Snippet = *snippet
+
+	if err := checkLink(link); err != nil {
+		return nil, err
+	}
+
+	metadata := &SnippetMetadata{
+		UserID:             snippet.UserID,
+		IsActive:           true,
+		ViewsLeft:          int64(link.MaxViews - link.CurrentViews),
+		Encryption:         snippet.Encryption,
+		Kind:               snippet.Kind,
+		FileSize:           snippet.FileSize,
+		PassphraseRequired: snippet.PassphraseProtected,
+		Restricted:         snippet.Restricted,
+		ExpiresAt:          link.ExpiresAt,
+	}
+	if snippet.PassphraseProtected {
+		metadata.AttemptsLeft = snippet.MaxAttempts - snippet.FailedAttempts
+	}
+
+	return metadata, nil
+}
+
+// RevealLink consumes one view of a recipient link and records who opened it.
+// It returns the decrypted snippet and the link with its updated counter.
+func (s SnippetService) RevealLink(ctx context.Context, linkID uuid.UUID, req RevealRequest) (_ *models.Snippet, _ *models.SnippetLink, err error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.RevealLink")
+	defer func() {
+		observeReveal("link", nil, err)
+		tracing.End(span, err)
+	}()
+
+	var link *models.SnippetLink
+	var snippet *models.Snippet
+	var plainText string
+	var failure error
+
+	err = s.repos.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		link, err = s.repos.Links.Get(ctx, linkID)
+		if err != nil {
+			return err
+		}
+
+		// Hold the snippet before the link's view is taken. A concurrent last own
+		// view updates the same row, so one of the two waits and sees the other's
+		// views when it decides whether the content must be burnt.
+		snippet, err = s.repos.Snippets.GetForUpdate(ctx, link.SnippetID)
+		if err != nil {
+			return err
+		}
+		link.Snippet = *snippet
+
+		if err := checkLink(link); err != nil {
+			return err
+		}
+
+		// A restricted snippet stays restricted through every link
+		if err := s.checkAccess(ctx, snippet, req.UserID); err != nil {
+			return err
+		}
+
+		// Passphrase protected? Check it before a view is consumed
+		plainText, err = s.checkPassphrase(ctx, snippet, req.Passphrase)
+		if err != nil {
+			// A wrong passphrase is counted, so commit and fail afterwards
+			if errors.Is(err, ErrWrongPassphrase) {
+				failure = err
+				return nil
+			}
+			return err
+		}
+
+		// Take one of the link's views and audit who opened it, the snippet's own counter is untouched
+		if err := s.repos.Links.ConsumeView(ctx, link.ID, &models.SnippetLinkView{
+			IP:        req.IP,
+			UserAgent: req.UserAgent,
+			ViewedAt:  time.Now(),
+		}); err != nil {
+			return err
+		}
+		link.CurrentViews++
+
+		// The link's last view may have been the last way to the content
+		if err := s.burnIfSpent(ctx, snippet); err != nil {
+			return err
+		}
+
+		// Notify the owner's webhooks as part of the same transaction
+		return s.emitView(ctx, snippet, link, req.UserID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if failure != nil {
+		return nil, nil, failure
+	}
+
+	revealed, err := openRevealed(ctx, snippet, plainText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return revealed, link, nil
+}
+
+// checkLink applies the burn/expiry rules of a link and its snippet (loaded into link.Snippet)
+func checkLink(link *models.SnippetLink) error {
+	if link.RevokedAt != nil {
+		return ErrRevoked
+	}
+
+	if time.Now().After(link.ExpiresAt) || time.Now().After(link.Snippet.ExpiresAt) {
+		return ErrExpired
+	}
+
+	// Running out of passphrase attempts destroys the secret for every link
+	snippet := link.Snippet
+	if snippet.BurntAt != nil {
+		return ErrBurnt
+	}
+	if snippet.PassphraseProtected && snippet.FailedAttempts >= snippet.MaxAttempts {
+		return ErrBurnt
+	}
+
+	if link.CurrentViews >= link.MaxViews {
+		return ErrBurnt
+	}
+
+	return nil
+}
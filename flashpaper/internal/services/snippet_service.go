@@ -2,137 +2,546 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"log/slog"
 	"strings"
 	"time"
 
+	"github.com/direwen/flashpaper/internal/metrics"
 	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/storage"
+	"github.com/direwen/flashpaper/internal/tracing"
 	"github.com/direwen/flashpaper/pkg/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type SnippetService struct {
-	db *gorm.DB
+	db    *gorm.DB // Webhook events, nil on in-memory repositories
+	repos repository.Repositories
+	blobs storage.BlobStore
 }
 
-func NewSnippetService(db *gorm.DB) *SnippetService {
-	return &SnippetService{db: db}
+func NewSnippetService(db *gorm.DB, repos repository.Repositories, blobs storage.BlobStore) *SnippetService {
+	return &SnippetService{db: db, repos: repos, blobs: blobs}
 }
 
-func (s SnippetService) CreateSnippet(
-	ctx context.Context,
-	userID uuid.UUID,
-	content,
-	title,
-	language string,
-	maxViews int,
-	expiresInMinutes int,
-) (*models.Snippet, error) {
+// Envelope is a snippet encrypted in the browser. The server stores it untouched,
+// the key only ever lives in the URL fragment of the link.
+type Envelope struct {
+	Algorithm  string     `json:"algorithm"`
+	Nonce      string     `json:"nonce"`
+	Ciphertext string     `json:"ciphertext"`
+	KDF        *KDFParams `json:"kdf,omitempty"`
+}
 
-	// Encrypt Content
-	content = strings.TrimSpace(content)
-	encrypted, err := utils.Encrypt(content)
-	if err != nil {
-		return nil, err
+// KDFParams describes how the client derived the key when it was passphrase based
+type KDFParams struct {
+	Name        string `json:"name"`
+	Salt        string `json:"salt"`
+	Hash        string `json:"hash,omitempty"`
+	Iterations  int    `json:"iterations,omitempty"`
+	Memory      int    `json:"memory,omitempty"`
+	Parallelism int    `json:"parallelism,omitempty"`
+}
+
+// Algorithms a client-side envelope may use
+var SupportedEnvelopeAlgorithms = map[string]bool{
+	"AES-GCM-256":        true,
+	"XChaCha20-Poly1305": true,
+}
+
+// BundleFile is one named file of a bundle snippet
+type BundleFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// MaxBundleFiles caps how many files a single bundle may hold
+const MaxBundleFiles = 20
+
+type CreateSnippetInput struct {
+	// Content is plaintext that the server encrypts
+	Content string
+	// Files turns the snippet into a bundle, Content must then be empty
+	Files []BundleFile
+	// Envelope replaces Content for client-side encrypted snippets
+	Envelope *Envelope
+	// Passphrase additionally seals Content, reveals must present it
+	Passphrase string
+	// MaxAttempts wrong passphrases burn the snippet
+	MaxAttempts int
+	// AllowedUsers (emails or user IDs) restricts reveals to those registered users
+	AllowedUsers     []string
+	Title            string
+	Language         string
+	MaxViews         int
+	ExpiresInMinutes int
+}
+
+// DefaultMaxPassphraseAttempts is used when a passphrase is set without an attempt limit
+const DefaultMaxPassphraseAttempts = 3
+
+func (s SnippetService) CreateSnippet(ctx context.Context, userID uuid.UUID, input CreateSnippetInput) (*models.Snippet, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.CreateSnippet")
+	defer span.End()
+
+	var content, encryption string
+	kind := models.KindText
+	if input.Envelope != nil {
+		// A client envelope is already sealed with whatever the client chose
+		if input.Passphrase != "" {
+			return nil, invalidInput("passphrase is not supported for client-side encrypted snippets")
+		}
+		if len(input.Files) > 0 {
+			return nil, invalidInput("bundles are not supported for client-side encrypted snippets")
+		}
+
+		// Store the envelope as-is, there is nothing for us to decrypt
+		envelope, err := sealEnvelope(input.Envelope)
+		if err != nil {
+			return nil, err
+		}
+		content, encryption = envelope, models.EncryptionClient
+	} else {
+		content = strings.TrimSpace(input.Content)
+
+		// A bundle is stored as one document so all files share a single view count
+		if len(input.Files) > 0 {
+			bundle, err := encodeBundle(input.Files)
+			if err != nil {
+				return nil, err
+			}
+			content, kind = bundle, models.KindBundle
+		}
+
+		// Seal with the passphrase first, the keyring layer goes on top
+		if input.Passphrase != "" {
+			sealed, err := sealWithPassphrase(ctx, content, input.Passphrase)
+			if err != nil {
+				return nil, err
+			}
+			content = sealed
+		}
+
+		// Encrypt Content
+		encrypted, err := encrypt(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		content, encryption = encrypted, models.EncryptionServer
+	}
+
+	maxAttempts := 0
+	if input.Passphrase != "" {
+		maxAttempts = input.MaxAttempts
+		if maxAttempts < 1 {
+			maxAttempts = DefaultMaxPassphraseAttempts
+		}
 	}
 
 	// Sanitize Title
-	title = strings.TrimSpace(title)
+	title := strings.TrimSpace(input.Title)
 	// Sanitize language
-	language = strings.ToLower(strings.TrimSpace(language))
+	language := strings.ToLower(strings.TrimSpace(input.Language))
 
 	// Calc Expiry
-	expiresAt := time.Now().Add(time.Minute * time.Duration(expiresInMinutes))
+	expiresAt := time.Now().Add(time.Minute * time.Duration(input.ExpiresInMinutes))
+
+	// Resolve the access policy
+	allowed, err := s.resolveAllowedUsers(ctx, input.AllowedUsers)
+	if err != nil {
+		return nil, err
+	}
 
 	// Prepare Model
 	snippet := &models.Snippet{
-		UserID:    userID,
-		Content:   encrypted,
-		Title:     title,
-		Language:  utils.SanitizeLanguage(language),
-		MaxViews:  maxViews,
-		ExpiresAt: expiresAt,
+		UserID:              userID,
+		Content:             content,
+		Encryption:          encryption,
+		Kind:                kind,
+		PassphraseProtected: input.Passphrase != "",
+		MaxAttempts:         maxAttempts,
+		Restricted:          len(allowed) > 0,
+		Title:               title,
+		Language:            utils.SanitizeLanguage(language),
+		MaxViews:            input.MaxViews,
+		ExpiresAt:           expiresAt,
 	}
 
-	if err := s.db.WithContext(ctx).Create(snippet).Error; err != nil {
+	if err := s.createWithAccess(ctx, snippet, allowed); err != nil {
 		return nil, err
 	}
 
 	return snippet, nil
 }
 
-func (s SnippetService) GetSnippet(ctx context.Context, snippetID string) (*models.Snippet, error) {
-	var snippet models.Snippet
+// encodeBundle validates the files of a bundle and serializes them for encryption
+func encodeBundle(files []BundleFile) (string, error) {
+	if len(files) > MaxBundleFiles {
+		return "", invalidInput("too many files in bundle")
+	}
 
-	// Validate uuid format
-	uid, err := uuid.Parse(snippetID)
+	seen := make(map[string]bool, len(files))
+	cleaned := make([]BundleFile, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSpace(file.Name)
+		if name == "" {
+			return "", invalidInput("every bundle file needs a name")
+		}
+		if seen[name] {
+			return "", invalidInput("duplicate file name in bundle: " + name)
+		}
+		seen[name] = true
+
+		cleaned = append(cleaned, BundleFile{
+			Name:     name,
+			Language: utils.SanitizeLanguage(strings.ToLower(strings.TrimSpace(file.Language))),
+			Content:  file.Content,
+		})
+	}
+
+	raw, err := json.Marshal(cleaned)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	return string(raw), nil
+}
 
-	// Start Transaction
-	tx := s.db.WithContext(ctx).Begin()
+// DecodeBundle parses the content of a revealed bundle snippet
+func DecodeBundle(snippet *models.Snippet) ([]BundleFile, error) {
+	if snippet.Kind != models.KindBundle {
+		return nil, errors.New("not a bundle snippet")
+	}
 
-	// If anything panics, Rollback changes
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	var files []BundleFile
+	if err := json.Unmarshal([]byte(snippet.Content), &files); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return files, nil
+}
+
+// openProtected removes the keyring layer and then the passphrase layer
+func openProtected(ctx context.Context, content, passphrase string) (string, error) {
+	sealed, err := decrypt(ctx, content)
+	if err != nil {
+		return "", err
+	}
+
+	// The key derivation is deliberately slow, it gets a span of its own
+	_, span := tracing.Start(ctx, "utils.OpenWithPassphrase")
+	defer span.End()
+	return utils.OpenWithPassphrase(sealed, passphrase)
+}
+
+// sealWithPassphrase is utils.SealWithPassphrase in a span, the key derivation is deliberately slow
+func sealWithPassphrase(ctx context.Context, content, passphrase string) (string, error) {
+	_, span := tracing.Start(ctx, "utils.SealWithPassphrase")
+	defer span.End()
+	return utils.SealWithPassphrase(content, passphrase)
+}
+
+// encrypt is utils.Encrypt in a span
+func encrypt(ctx context.Context, content string) (string, error) {
+	_, span := tracing.Start(ctx, "utils.Encrypt")
+	defer span.End()
+	return utils.Encrypt(content)
+}
+
+// decrypt is utils.Decrypt in a span
+func decrypt(ctx context.Context, content string) (string, error) {
+	_, span := tracing.Start(ctx, "utils.Decrypt")
+	defer span.End()
+	return utils.Decrypt(content)
+}
+
+// sealEnvelope validates a client-side envelope and serializes it for storage
+func sealEnvelope(envelope *Envelope) (string, error) {
+	if !SupportedEnvelopeAlgorithms[envelope.Algorithm] {
+		return "", invalidInput("unsupported envelope algorithm")
+	}
+
+	// Only check the shape, the bytes themselves are opaque to us
+	for _, field := range []string{envelope.Nonce, envelope.Ciphertext} {
+		if _, err := base64.StdEncoding.DecodeString(field); err != nil || field == "" {
+			return "", invalidInput("envelope nonce and ciphertext must be base64")
+		}
+	}
+	if envelope.KDF != nil {
+		if envelope.KDF.Name == "" {
+			return "", invalidInput("envelope kdf name is required")
 		}
+		if _, err := base64.StdEncoding.DecodeString(envelope.KDF.Salt); err != nil || envelope.KDF.Salt == "" {
+			return "", invalidInput("envelope kdf salt must be base64")
+		}
+	}
+
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func (s SnippetService) GetSnippet(ctx context.Context, snippetID string, req RevealRequest) (revealed *models.Snippet, err error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.GetSnippet")
+	defer func() {
+		observeReveal("snippet", revealed, err)
+		tracing.End(span, err)
 	}()
 
-	// Lock the row
-	if err := tx.Clauses(clause.Locking{
-		Strength: clause.LockingStrengthUpdate,
-	}).First(&snippet, uid).Error; err != nil {
-		tx.Rollback()
+	// A malformed ID names no snippet, that's the caller's mistake and not ours
+	uid, err := uuid.Parse(snippetID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var snippet *models.Snippet
+	var plainText string
+	var failure error
+
+	err = s.repos.Transaction(ctx, func(ctx context.Context) error {
+		current, err := s.repos.Snippets.Get(ctx, uid)
+		if err != nil {
+			return err
+		}
+
+		// Expired?
+		if time.Now().After(current.ExpiresAt) {
+			return ErrExpired
+		}
+
+		// Burnt? (Views > MaxViews)
+		if current.CurrentViews >= current.MaxViews {
+			return ErrBurnt
+		}
+
+		// Restricted to named users?
+		if err := s.checkAccess(ctx, current, req.UserID); err != nil {
+			return err
+		}
+
+		// Passphrase protected? Check it before a view is consumed
+		plainText, err = s.checkPassphrase(ctx, current, req.Passphrase)
+		if err != nil {
+			// A wrong passphrase is counted, so commit and fail afterwards
+			if errors.Is(err, ErrWrongPassphrase) {
+				failure = err
+				return nil
+			}
+			return err
+		}
+
+		// Take the view, this is where a concurrent reveal of the last view loses.
+		// The update holds the row, so a link reveal racing it waits in RevealLink.
+		snippet, err = s.repos.Snippets.ConsumeView(ctx, uid, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// The last view wipes the content in the same transaction, the reader
+		// gets it from memory and only a tombstone is committed
+		if err := s.burnIfSpent(ctx, snippet); err != nil {
+			return err
+		}
+
+		// Notify the owner's webhooks as part of the same transaction
+		return s.emitView(ctx, snippet, nil, req.UserID)
+	})
+	if err != nil {
 		return nil, err
 	}
+	if failure != nil {
+		return nil, failure
+	}
 
-	// Expired?
-	if time.Now().After(snippet.ExpiresAt) {
-		tx.Rollback()
-		return nil, errors.New("expired")
+	return openRevealed(ctx, snippet, plainText)
+}
+
+// checkPassphrase verifies the passphrase of a snippet and returns the decrypted
+// content (unprotected snippets pass straight through with ""). A wrong passphrase
+// is counted, burning the snippet once the attempts run out, so the caller must
+// commit before it fails the reveal.
+func (s SnippetService) checkPassphrase(ctx context.Context, snippet *models.Snippet, passphrase string) (string, error) {
+	if !snippet.PassphraseProtected {
+		return "", nil
 	}
 
-	// Burnt? (Views > MaxViews)
-	if snippet.CurrentViews >= snippet.MaxViews {
-		tx.Rollback()
-		return nil, errors.New("burnt")
+	if passphrase == "" {
+		return "", ErrPassphraseRequired
 	}
 
-	// Increment View
-	snippet.CurrentViews++
-	if err := tx.Save(&snippet).Error; err != nil {
-		tx.Rollback()
-		return nil, err
+	plainText, err := openProtected(ctx, snippet.Content, passphrase)
+	if errors.Is(err, utils.ErrWrongPassphrase) {
+		updated, err := s.repos.Snippets.RecordFailedAttempt(ctx, snippet.ID)
+		if err != nil {
+			return "", err
+		}
+		// Only the attempt that used up the last one announces the burn. A wrong
+		// passphrase always commits, so the burn can be counted right away.
+		if updated.FailedAttempts == updated.MaxAttempts {
+			// Every link dies with it, nobody needs the content anymore
+			if err := s.burn(ctx, updated); err != nil {
+				return "", err
+			}
+			metrics.SnippetBurns.WithLabelValues("passphrase_attempts").Inc()
+			data := snippetEvent(updated)
+			data.Reason = "passphrase_attempts"
+			if err := s.emit(ctx, updated.UserID, models.EventSnippetBurnt, data); err != nil {
+				return "", err
+			}
+		}
+		return "", ErrWrongPassphrase
+	}
+	if err != nil {
+		return "", ErrDecryptionFailed
 	}
 
-	// Commit
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
-		return nil, err
+	return plainText, nil
+}
+
+// emit queues a webhook event in the transaction of ctx. Webhooks are kept in
+// SQL, a service on in-memory repositories has none to notify.
+func (s SnippetService) emit(ctx context.Context, ownerID uuid.UUID, event string, data WebhookEventData) error {
+	if s.db == nil {
+		return nil
+	}
+	return EmitSnippetEvent(repository.Conn(ctx, s.db), ownerID, event, data)
+}
+
+// emitView queues the viewed event of a consumed reveal, and the burnt event when
+// it took the snippet's last view. link is nil for reveals of the snippet itself.
+func (s SnippetService) emitView(ctx context.Context, snippet *models.Snippet, link *models.SnippetLink, viewerID uuid.UUID) error {
+	data := snippetEvent(snippet)
+	viewsLeft := snippet.MaxViews - snippet.CurrentViews
+	if link != nil {
+		viewsLeft = link.MaxViews - link.CurrentViews
+		data.LinkID = &link.ID
+		data.LinkLabel = link.Label
+	}
+	data.ViewsLeft = &viewsLeft
+	if viewerID != uuid.Nil {
+		data.ViewerID = &viewerID
+	}
+
+	if err := s.emit(ctx, snippet.UserID, models.EventSnippetViewed, data); err != nil {
+		return err
+	}
+
+	// A link running dry leaves the snippet itself alive
+	if link != nil || viewsLeft > 0 {
+		return nil
+	}
+
+	burnt := snippetEvent(snippet)
+	burnt.Reason = "max_views"
+	return s.emit(ctx, snippet.UserID, models.EventSnippetBurnt, burnt)
+}
+
+// burnIfSpent burns a snippet in the transaction of ctx once nothing can reveal
+// it anymore: its own views are used up and none of its links has a view left.
+// The caller must hold the snippet row, or a concurrent reveal may burn nothing.
+func (s SnippetService) burnIfSpent(ctx context.Context, snippet *models.Snippet) error {
+	if snippet.CurrentViews < snippet.MaxViews || snippet.BurntAt != nil {
+		return nil
+	}
+
+	live, err := s.repos.Links.CountLive(ctx, snippet.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if live > 0 {
+		return nil
+	}
+
+	return s.burn(ctx, snippet)
+}
+
+// burn wipes the content of a snippet in the transaction of ctx, so no backup
+// taken afterwards holds it. The row stays as a tombstone of its metadata, the
+// caller keeps the content it already loaded.
+func (s SnippetService) burn(ctx context.Context, snippet *models.Snippet) error {
+	now := time.Now()
+	if err := s.repos.Snippets.Purge(ctx, snippet.ID, now); err != nil {
+		return err
+	}
+	snippet.BurntAt = &now
+
+	// The owner's history remembers it after the janitor removes the row
+	return BurySnippet(ctx, s.repos, snippet, BurnReason(snippet), now)
+}
+
+// deleteBurntBlob removes the blob of a burnt file snippet once nobody needs it.
+// Blobs are outside the transaction, one that fails is left to the janitor.
+func (s SnippetService) deleteBurntBlob(ctx context.Context, snippet *models.Snippet) {
+	if snippet.BurntAt == nil || snippet.BlobKey == "" {
+		return
+	}
+	if err := s.blobs.Delete(context.WithoutCancel(ctx), snippet.BlobKey); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete blob of burnt snippet", "snippet_id", snippet.ID, "error", err)
+	}
+}
+
+// openRevealed turns the stored content of a consumed snippet into what the reader gets
+func openRevealed(ctx context.Context, snippet *models.Snippet, plainText string) (*models.Snippet, error) {
+	// Client-side encrypted snippets are handed back untouched
+	if snippet.Encryption == models.EncryptionClient {
+		return snippet, nil
+	}
+
+	// Already decrypted while checking the passphrase
+	if snippet.PassphraseProtected {
+		snippet.Content = plainText
+		return snippet, nil
 	}
 
 	// If successful, decrypt the content
-	decrypted, err := utils.Decrypt(snippet.Content)
+	decrypted, err := decrypt(ctx, snippet.Content)
 	if err != nil {
-		return nil, errors.New("decryption_failed")
+		return nil, ErrDecryptionFailed
 	}
 	snippet.Content = decrypted
 
-	return &snippet, nil
+	return snippet, nil
 }
 
 func (s SnippetService) DeleteSnippet(ctx context.Context, snippetID uuid.UUID, userID uuid.UUID) error {
-	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", snippetID, userID).Delete(&models.Snippet{})
-	if err := result.Error; err != nil {
+	ctx, span := tracing.Start(ctx, "SnippetService.DeleteSnippet")
+	defer span.End()
+
+	snippet, err := s.repos.Snippets.Get(ctx, snippetID)
+	if err != nil {
 		return err
 	}
+	if snippet.UserID != userID {
+		return ErrNotFound
+	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("not_found")
+	err = s.repos.Transaction(ctx, func(ctx context.Context) error {
+		// Recorded first, the link views go with the row
+		if err := BurySnippet(ctx, s.repos, snippet, models.TombstoneDeleted, time.Now()); err != nil {
+			return err
+		}
+		if err := s.repos.Snippets.Delete(ctx, snippetID, userID); err != nil {
+			return err
+		}
+
+		return s.emit(ctx, userID, models.EventSnippetDeleted, snippetEvent(snippet))
+	})
+	if err != nil {
+		return err
+	}
+
+	// The row is gone, so the blob is unreachable either way. A failure here only leaves
+	// ciphertext behind, it never resurrects the snippet.
+	if snippet.BlobKey != "" {
+		if err := s.blobs.Delete(ctx, snippet.BlobKey); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete blob of snippet", "snippet_id", snippet.ID, "error", err)
+		}
 	}
 
 	return nil
@@ -145,56 +554,43 @@ type DashboardStats struct {
 }
 
 func (s SnippetService) GetDashboardStats(ctx context.Context, userID uuid.UUID) (*DashboardStats, error) {
-	var stats DashboardStats
-
-	// Active snippets (not expired, not burnt)
-	if err := s.db.WithContext(ctx).
-		Model(&models.Snippet{}).
-		Where("user_id = ? AND expires_at > ? AND current_views < max_views", userID, time.Now()).
-		Count(&stats.ActiveSnippets).Error; err != nil {
-		return nil, err
-	}
+	ctx, span := tracing.Start(ctx, "SnippetService.GetDashboardStats")
+	defer span.End()
 
-	// Burnt snippets (not expired but reached max views)
-	if err := s.db.WithContext(ctx).
-		Model(&models.Snippet{}).
-		Where("user_id = ? AND expires_at > ? AND current_views >= max_views", userID, time.Now()).
-		Count(&stats.ActiveBurntSnippets).Error; err != nil {
-		return nil, err
-	}
-
-	// Total views across all active snippets
-	var totalViews *int64
-	if err := s.db.WithContext(ctx).
-		Model(&models.Snippet{}).
-		Where("user_id = ? AND expires_at > ? AND current_views < max_views", userID, time.Now()).
-		Select("SUM(current_views)").
-		Scan(&totalViews).Error; err != nil {
+	stats, err := s.repos.Snippets.Stats(ctx, userID, time.Now())
+	if err != nil {
 		return nil, err
 	}
-	if totalViews != nil {
-		stats.TotalViews = *totalViews
-	}
 
-	return &stats, nil
+	return &DashboardStats{
+		ActiveSnippets:      stats.Active,
+		ActiveBurntSnippets: stats.Burnt,
+		TotalViews:          stats.TotalViews,
+	}, nil
 }
 
 type OverviewSnippet struct {
 	ID           uuid.UUID `json:"id"`
 	Title        string    `json:"title"`
 	Language     string    `json:"language"`
+	Kind         string    `json:"kind"`
+	FileSize     int64     `json:"file_size,omitempty"`
 	MaxViews     int       `json:"max_views"`
 	CurrentViews int       `json:"current_views"`
 	ExpiresAt    time.Time `json:"expires_at"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
-func (s SnippetService) GetActiveSnippets(ctx context.Context, UserID uuid.UUID, page, limit int) ([]OverviewSnippet, int64, error) {
-	var snippets []OverviewSnippet
-	var total int64
+// Page is where a paged listing is, after clamping what the caller asked for
+type Page struct {
+	Number int // From 1
+	Limit  int // Items per page, 1 to 100
+	Total  int64
+}
 
-	if page < 1 {
-		page = 1
+func newPage(number, limit int) Page {
+	if number < 1 {
+		number = 1
 	}
 
 	if limit < 1 {
@@ -205,68 +601,98 @@ func (s SnippetService) GetActiveSnippets(ctx context.Context, UserID uuid.UUID,
 		limit = 100
 	}
 
-	offset := (page - 1) * limit
+	return Page{Number: number, Limit: limit}
+}
 
-	query := s.db.WithContext(ctx).
-		Model(&models.Snippet{}).
-		Where("user_id = ?", UserID).
-		Where("expires_at > ?", time.Now())
+func (p Page) offset() int {
+	return (p.Number - 1) * p.Limit
+}
 
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, err
-	}
+// TotalPages is how many pages the listing has, zero when it is empty
+func (p Page) TotalPages() int {
+	return int((p.Total + int64(p.Limit) - 1) / int64(p.Limit))
+}
 
-	query = query.
-		Select("id", "title", "language", "max_views", "current_views", "expires_at", "created_at").
-		Order("created_at DESC").
-		Offset(offset).
-		Limit(limit).
-		Find(&snippets)
+func (s SnippetService) GetActiveSnippets(ctx context.Context, UserID uuid.UUID, page, limit int) ([]OverviewSnippet, Page, error) {
+	ctx, span := tracing.Start(ctx, "SnippetService.GetActiveSnippets")
+	defer span.End()
 
-	if err := query.Error; err != nil {
-		return nil, 0, err
+	p := newPage(page, limit)
+	snippets, total, err := s.repos.Snippets.ListActive(ctx, UserID, time.Now(), p.offset(), p.Limit)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	p.Total = total
+
+	overviews := make([]OverviewSnippet, 0, len(snippets))
+	for _, snippet := range snippets {
+		overviews = append(overviews, OverviewSnippet{
+			ID:           snippet.ID,
+			Title:        snippet.Title,
+			Language:     snippet.Language,
+			Kind:         snippet.Kind,
+			FileSize:     snippet.FileSize,
+			MaxViews:     snippet.MaxViews,
+			CurrentViews: snippet.CurrentViews,
+			ExpiresAt:    snippet.ExpiresAt,
+			CreatedAt:    snippet.CreatedAt,
+		})
 	}
 
-	return snippets, total, nil
+	return overviews, p, nil
 }
 
 type SnippetMetadata struct {
-	UserID    uuid.UUID `json:"user_id"`
-	IsActive  bool      `json:"is_active"`
-	ViewsLeft int64     `json:"views_left"`
-	ExpiresAt time.Time `json:"expires_at"`
+	UserID     uuid.UUID `json:"user_id"`
+	IsActive   bool      `json:"is_active"`
+	ViewsLeft  int64     `json:"views_left"`
+	Encryption string    `json:"encryption"`
+	Kind       string    `json:"kind"`
+	FileSize   int64     `json:"file_size,omitempty"`
+	// PassphraseRequired tells the client to prompt before revealing
+	PassphraseRequired bool      `json:"passphrase_required"`
+	Restricted         bool      `json:"restricted"` // Sign-in as an allowed user is required
+	AttemptsLeft       int       `json:"attempts_left,omitempty"`
+	ExpiresAt          time.Time `json:"expires_at"`
 }
 
 func (s SnippetService) GetSnippetMetadata(ctx context.Context, snippetID string) (*SnippetMetadata, error) {
-	var snippet models.Snippet
+	ctx, span := tracing.Start(ctx, "SnippetService.GetSnippetMetadata")
+	defer span.End()
 
-	// Validate uuid
+	// A malformed ID names no snippet, that's the caller's mistake and not ours
 	uid, err := uuid.Parse(snippetID)
 	if err != nil {
-		return nil, err
+		return nil, ErrNotFound
 	}
 
-	// Get only required values at the high-risk endpoint
-	query := s.db.WithContext(ctx).
-		Select("user_id", "max_views", "current_views", "expires_at").
-		First(&snippet, uid)
-
-	if err := query.Error; err != nil {
-		return nil, errors.New("not_found")
+	snippet, err := s.repos.Snippets.Get(ctx, uid)
+	if err != nil {
+		return nil, err
 	}
 
 	if time.Now().After(snippet.ExpiresAt) {
-		return nil, errors.New("expired")
+		return nil, ErrExpired
 	}
 
 	if snippet.CurrentViews >= snippet.MaxViews {
-		return nil, errors.New("burnt")
+		return nil, ErrBurnt
 	}
 
-	return &SnippetMetadata{
-		UserID:    snippet.UserID,
-		IsActive:  true,
-		ViewsLeft: int64(snippet.MaxViews - snippet.CurrentViews),
-		ExpiresAt: snippet.ExpiresAt,
-	}, nil
+	metadata := &SnippetMetadata{
+		UserID:             snippet.UserID,
+		IsActive:           true,
+		ViewsLeft:          int64(snippet.MaxViews - snippet.CurrentViews),
+		Encryption:         snippet.Encryption,
+		Kind:               snippet.Kind,
+		FileSize:           snippet.FileSize,
+		PassphraseRequired: snippet.PassphraseProtected,
+		Restricted:         snippet.Restricted,
+		ExpiresAt:          snippet.ExpiresAt,
+	}
+	if snippet.PassphraseProtected {
+		metadata.AttemptsLeft = snippet.MaxAttempts - snippet.FailedAttempts
+	}
+
+	return metadata, nil
 }
@@ -0,0 +1,420 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/migrations"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/internal/storage"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// newSnippetService returns a service on in-memory repositories with one registered user
+func newSnippetService(t *testing.T) (*services.SnippetService, repository.Repositories, *models.User) {
+	t.Helper()
+	return newSnippetServiceOn(t, nil, repository.NewMemory())
+}
+
+// newSQLiteSnippetService is newSnippetService on a migrated SQLite database
+func newSQLiteSnippetService(t *testing.T) (*services.SnippetService, repository.Repositories, *models.User) {
+	t.Helper()
+
+	db := newSQLiteDB(t)
+	return newSnippetServiceOn(t, db, repository.NewGorm(db))
+}
+
+// newSQLiteDB opens a fresh SQLite database with every migration applied
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	config.ConnectDB(config.Database{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "flashpaper.db")})
+	db := config.DB
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newSnippetServiceOn(t *testing.T, db *gorm.DB, repos repository.Repositories) (*services.SnippetService, repository.Repositories, *models.User) {
+	t.Helper()
+
+	if err := utils.LoadKeyring("", "", "0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := newUser(t, repos, "owner@example.com")
+	return services.NewSnippetService(db, repos, blobs), repos, owner
+}
+
+func newUser(t *testing.T, repos repository.Repositories, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Email: email, Password: "unused"}
+	if err := repos.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createSnippet(t *testing.T, s *services.SnippetService, owner *models.User, input services.CreateSnippetInput) *models.Snippet {
+	t.Helper()
+
+	if input.Content == "" {
+		input.Content = "hunter2"
+	}
+	if input.ExpiresInMinutes == 0 {
+		input.ExpiresInMinutes = 60
+	}
+	snippet, err := s.CreateSnippet(context.Background(), owner.ID, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snippet
+}
+
+func history(t *testing.T, s *services.SnippetService, owner *models.User) []services.HistorySnippet {
+	t.Helper()
+
+	entries, _, err := s.GetSnippetHistory(context.Background(), owner.ID, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestGetSnippetBurnsOnLastView(t *testing.T) {
+	s, repos, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+
+	revealed, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revealed.Content != "hunter2" {
+		t.Fatalf("got content %q, want the plaintext", revealed.Content)
+	}
+
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{}); !errors.Is(err, services.ErrBurnt) {
+		t.Fatalf("second reveal: got %v, want ErrBurnt", err)
+	}
+
+	stored, err := repos.Snippets.Get(ctx, snippet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.BurntAt == nil || stored.Content != "" {
+		t.Fatalf("burnt snippet kept content %q, burnt_at %v", stored.Content, stored.BurntAt)
+	}
+
+	entries := history(t, s, owner)
+	if len(entries) != 1 || entries[0].Reason != models.TombstoneMaxViews {
+		t.Fatalf("got history %+v, want one max_views tombstone", entries)
+	}
+}
+
+func TestConcurrentRevealsNeverExceedMaxViews(t *testing.T) {
+	s, _, owner := newSnippetService(t)
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 3})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	revealed := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.GetSnippet(context.Background(), snippet.ID.String(), services.RevealRequest{}); err == nil {
+				mu.Lock()
+				revealed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if revealed != 3 {
+		t.Fatalf("got %d reveals, want 3", revealed)
+	}
+}
+
+func TestWrongPassphraseBurnsOnLastAttempt(t *testing.T) {
+	s, _, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 5, Passphrase: "open sesame", MaxAttempts: 2})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{Passphrase: "wrong"}); !errors.Is(err, services.ErrWrongPassphrase) {
+			t.Fatalf("attempt %d: got %v, want ErrWrongPassphrase", attempt, err)
+		}
+	}
+
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{Passphrase: "open sesame"}); !errors.Is(err, services.ErrBurnt) {
+		t.Fatalf("after the last attempt: got %v, want ErrBurnt", err)
+	}
+
+	entries := history(t, s, owner)
+	if len(entries) != 1 || entries[0].Reason != models.TombstonePassphraseAttempts {
+		t.Fatalf("got history %+v, want one passphrase_attempts tombstone", entries)
+	}
+}
+
+func TestRestrictedSnippetOnlyRevealsToAllowedUsers(t *testing.T) {
+	s, repos, owner := newSnippetService(t)
+	ctx := context.Background()
+	allowed := newUser(t, repos, "Alice@Example.com")
+	stranger := newUser(t, repos, "mallory@example.com")
+
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 5, AllowedUsers: []string{"alice@example.com"}})
+
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{}); !errors.Is(err, services.ErrLoginRequired) {
+		t.Fatalf("anonymous: got %v, want ErrLoginRequired", err)
+	}
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{UserID: stranger.ID}); !errors.Is(err, services.ErrForbidden) {
+		t.Fatalf("stranger: got %v, want ErrForbidden", err)
+	}
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{UserID: allowed.ID}); err != nil {
+		t.Fatalf("allowed user: got %v", err)
+	}
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{UserID: owner.ID}); err != nil {
+		t.Fatalf("owner: got %v", err)
+	}
+
+	if _, err := s.CreateSnippet(ctx, owner.ID, services.CreateSnippetInput{
+		Content: "x", MaxViews: 1, ExpiresInMinutes: 60, AllowedUsers: []string{"nobody@example.com"},
+	}); !errors.As(err, new(*services.ValidationError)) {
+		t.Fatalf("unknown allowed user: got %v, want a ValidationError", err)
+	}
+}
+
+func TestLinkKeepsSnippetAliveUntilItRunsOut(t *testing.T) {
+	s, _, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+
+	link, err := s.CreateLink(ctx, owner.ID, snippet.ID, services.CreateLinkInput{Label: "alice", MaxViews: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The snippet's own last view leaves the link working
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetLinkMetadata(ctx, link.ID); err != nil {
+		t.Fatalf("link after the snippet's own last view: got %v", err)
+	}
+
+	revealed, usedLink, err := s.RevealLink(ctx, link.ID, services.RevealRequest{IP: "203.0.113.7", UserAgent: "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revealed.Content != "hunter2" || usedLink.CurrentViews != 1 {
+		t.Fatalf("got content %q and %d link views", revealed.Content, usedLink.CurrentViews)
+	}
+
+	if _, _, err := s.RevealLink(ctx, link.ID, services.RevealRequest{}); !errors.Is(err, services.ErrBurnt) {
+		t.Fatalf("spent link: got %v, want ErrBurnt", err)
+	}
+
+	links, err := s.ListLinks(ctx, owner.ID, snippet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || len(links[0].Views) != 1 || links[0].Views[0].IP != "203.0.113.7" {
+		t.Fatalf("got links %+v, want one audited view", links)
+	}
+
+	entries := history(t, s, owner)
+	if len(entries) != 1 || entries[0].LinkViews != 1 {
+		t.Fatalf("got history %+v, want one tombstone counting the link view", entries)
+	}
+}
+
+func TestConcurrentLastViewsWipeContent(t *testing.T) {
+	for name, open := range map[string]func(*testing.T) (*services.SnippetService, repository.Repositories, *models.User){
+		"memory": newSnippetService,
+		"sqlite": newSQLiteSnippetService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, repos, owner := open(t)
+			ctx := context.Background()
+
+			for round := range 10 {
+				snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+				link, err := s.CreateLink(ctx, owner.ID, snippet.ID, services.CreateLinkInput{MaxViews: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The snippet's own last view and its only link's last view at once
+				var wg sync.WaitGroup
+				errs := make([]error, 2)
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_, errs[0] = s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{})
+				}()
+				go func() {
+					defer wg.Done()
+					_, _, errs[1] = s.RevealLink(ctx, link.ID, services.RevealRequest{})
+				}()
+				wg.Wait()
+				if err := errors.Join(errs...); err != nil {
+					t.Fatalf("round %d: %v", round, err)
+				}
+
+				stored, err := repos.Snippets.Get(ctx, snippet.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.BurntAt == nil || stored.Content != "" {
+					t.Fatalf("round %d: spent snippet kept content %q, burnt_at %v", round, stored.Content, stored.BurntAt)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokingLastLinkBurnsSpentSnippet(t *testing.T) {
+	s, repos, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+
+	link, err := s.CreateLink(ctx, owner.ID, snippet.ID, services.CreateLinkInput{Label: "bob", MaxViews: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSnippet(ctx, snippet.ID.String(), services.RevealRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stranger := newUser(t, repos, "mallory@example.com")
+	if err := s.RevokeLink(ctx, stranger.ID, snippet.ID, link.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("revoke by a stranger: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.RevokeLink(ctx, owner.ID, snippet.ID, link.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RevealLink(ctx, link.ID, services.RevealRequest{}); !errors.Is(err, services.ErrRevoked) {
+		t.Fatalf("revoked link: got %v, want ErrRevoked", err)
+	}
+
+	stored, err := repos.Snippets.Get(ctx, snippet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.BurntAt == nil {
+		t.Fatal("snippet with no live link left was not burnt")
+	}
+}
+
+func TestLinksOfOtherOwnersAreNotFound(t *testing.T) {
+	s, repos, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+	stranger := newUser(t, repos, "mallory@example.com")
+
+	if _, err := s.CreateLink(ctx, stranger.ID, snippet.ID, services.CreateLinkInput{MaxViews: 1}); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("create: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.ListLinks(ctx, stranger.ID, snippet.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("list: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetLinkMetadata(ctx, uuid.New()); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("metadata of an unknown link: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestDeleteSnippetLeavesTombstone(t *testing.T) {
+	s, repos, owner := newSnippetService(t)
+	ctx := context.Background()
+	snippet := createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 2})
+	if _, err := s.CreateLink(ctx, owner.ID, snippet.ID, services.CreateLinkInput{MaxViews: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteSnippet(ctx, snippet.ID, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Snippets.Get(ctx, snippet.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("deleted snippet: got %v, want ErrNotFound", err)
+	}
+	if live, _ := repos.Links.CountLive(ctx, snippet.ID, snippet.CreatedAt); live != 0 {
+		t.Fatalf("deleted snippet kept %d links", live)
+	}
+
+	entries := history(t, s, owner)
+	if len(entries) != 1 || entries[0].Reason != models.TombstoneDeleted {
+		t.Fatalf("got history %+v, want one deleted tombstone", entries)
+	}
+}
+
+func TestMalformedSnippetIDIsNotFound(t *testing.T) {
+	s, _, _ := newSnippetService(t)
+	ctx := context.Background()
+
+	if _, err := s.GetSnippetMetadata(ctx, "not-a-uuid"); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("metadata: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetSnippet(ctx, "not-a-uuid", services.RevealRequest{}); !errors.Is(err, services.ErrNotFound) {
+		t.Fatalf("reveal: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestListingsReportTheClampedPage(t *testing.T) {
+	s, _, owner := newSnippetService(t)
+	ctx := context.Background()
+	for range 3 {
+		createSnippet(t, s, owner, services.CreateSnippetInput{MaxViews: 1})
+	}
+
+	_, page, err := s.GetActiveSnippets(ctx, owner.ID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Number != 1 || page.Limit != 10 || page.Total != 3 || page.TotalPages() != 1 {
+		t.Fatalf("got %+v with %d pages, want page 1 of 1 by 10", page, page.TotalPages())
+	}
+
+	active, page, err := s.GetActiveSnippets(ctx, owner.ID, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || page.TotalPages() != 2 {
+		t.Fatalf("got %d snippets on %+v, want the last one of 2 pages", len(active), page)
+	}
+
+	_, page, err = s.GetSnippetHistory(ctx, owner.ID, 1, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Limit != 100 || page.TotalPages() != 0 {
+		t.Fatalf("got %+v, want an empty history capped at 100 per page", page)
+	}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// MaxWebhooksPerUser caps how many endpoints one account can register
+const MaxWebhooksPerUser = 10
+
+// Events a webhook may subscribe to
+var WebhookEvents = map[string]bool{
+	models.EventSnippetViewed:  true,
+	models.EventSnippetBurnt:   true,
+	models.EventSnippetExpired: true,
+	models.EventSnippetDeleted: true,
+}
+
+type WebhookService struct {
+	db *gorm.DB
+	// allowInsecure accepts plain http endpoints, for local development only
+	allowInsecure bool
+}
+
+func NewWebhookService(db *gorm.DB, allowInsecure bool) *WebhookService {
+	return &WebhookService{
+		db:            db,
+		allowInsecure: allowInsecure,
+	}
+}
+
+type CreateWebhookInput struct {
+	URL    string
+	Events []string
+}
+
+// CreateWebhook registers an endpoint and returns it with its signing secret.
+// The secret is only ever shown here, it is stored encrypted.
+func (s *WebhookService) CreateWebhook(ctx context.Context, userID uuid.UUID, input CreateWebhookInput) (*models.Webhook, string, error) {
+	endpoint, err := url.Parse(strings.TrimSpace(input.URL))
+	if err != nil || endpoint.Host == "" {
+		return nil, "", invalidInput("invalid webhook url")
+	}
+	if endpoint.Scheme != "https" && !(s.allowInsecure && endpoint.Scheme == "http") {
+		return nil, "", invalidInput("webhook url must use https")
+	}
+
+	events := make([]string, 0, len(input.Events))
+	seen := map[string]bool{}
+	for _, event := range input.Events {
+		if !WebhookEvents[event] {
+			return nil, "", invalidInput("unknown webhook event: " + event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return nil, "", invalidInput("no webhook events")
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count >= MaxWebhooksPerUser {
+		return nil, "", ErrTooManyWebhooks
+	}
+
+	secret, err := utils.GenerateWebhookSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	encryptedSecret, err := utils.Encrypt(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	webhook := &models.Webhook{
+		UserID: userID,
+		URL:    endpoint.String(),
+		Secret: encryptedSecret,
+		Events: strings.Join(events, ","),
+	}
+
+	if err := s.db.WithContext(ctx).Create(webhook).Error; err != nil {
+		return nil, "", err
+	}
+
+	return webhook, secret, nil
+}
+
+type WebhookOverview struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context, userID uuid.UUID) ([]WebhookOverview, error) {
+	var webhooks []models.Webhook
+	if err := s.db.WithContext(ctx).
+		Select("id", "url", "events", "created_at").
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	overviews := make([]WebhookOverview, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		overviews = append(overviews, WebhookOverview{
+			ID:        webhook.ID,
+			URL:       webhook.URL,
+			Events:    strings.Split(webhook.Events, ","),
+			CreatedAt: webhook.CreatedAt,
+		})
+	}
+
+	return overviews, nil
+}
+
+// DeleteWebhook removes an endpoint together with its pending deliveries and log
+func (s *WebhookService) DeleteWebhook(ctx context.Context, userID, webhookID uuid.UUID) error {
+	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", webhookID, userID).Delete(&models.Webhook{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type DeliveryOverview struct {
+	ID             uuid.UUID       `json:"id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// ListDeliveries returns the most recent deliveries of one of the user's webhooks
+func (s *WebhookService) ListDeliveries(ctx context.Context, userID, webhookID uuid.UUID, limit int) ([]DeliveryOverview, error) {
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	var count int64
+	if err := s.db.WithContext(ctx).
+		Model(&models.Webhook{}).
+		Where("id = ? AND user_id = ?", webhookID, userID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := s.db.WithContext(ctx).
+		Where("webhook_id = ?", webhookID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+
+	overviews := make([]DeliveryOverview, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		overview := DeliveryOverview{
+			ID:             delivery.ID,
+			Event:          delivery.Event,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			DeliveredAt:    delivery.DeliveredAt,
+			CreatedAt:      delivery.CreatedAt,
+			Payload:        json.RawMessage(delivery.Payload),
+		}
+		if delivery.Status == models.DeliveryPending {
+			overview.NextAttemptAt = &delivery.NextAttemptAt
+		}
+		overviews = append(overviews, overview)
+	}
+
+	return overviews, nil
+}
+
+// WebhookEvent is the JSON body POSTed to webhook endpoints. ID is shared by all
+// deliveries of the same event, so receivers can deduplicate retries with it.
+type WebhookEvent struct {
+	ID        uuid.UUID        `json:"id"`
+	Event     string           `json:"event"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+// WebhookEventData describes the snippet an event is about. It never carries content.
+type WebhookEventData struct {
+	SnippetID uuid.UUID  `json:"snippet_id"`
+	Title     string     `json:"title,omitempty"`
+	Kind      string     `json:"kind"`
+	ViewsLeft *int       `json:"views_left,omitempty"`
+	ViewerID  *uuid.UUID `json:"viewer_id,omitempty"` // Signed-in reader of a restricted snippet
+	LinkID    *uuid.UUID `json:"link_id,omitempty"`   // Set when the view came through a recipient link
+	LinkLabel string     `json:"link_label,omitempty"`
+	Reason    string     `json:"reason,omitempty"` // Why a snippet burnt: max_views or passphrase_attempts
+}
+
+// EmitSnippetEvent queues an event for every webhook of the owner subscribed to it.
+// It writes through tx, so the event only goes out if the change that caused it commits.
+func EmitSnippetEvent(tx *gorm.DB, ownerID uuid.UUID, event string, data WebhookEventData) error {
+	var webhooks []models.Webhook
+	if err := tx.Select("id", "events").Where("user_id = ?", ownerID).Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	var deliveries []models.WebhookDelivery
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !subscribes(webhook.Events, event) {
+			continue
+		}
+
+		// Encode lazily, most owners have no webhooks at all
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(WebhookEvent{
+				ID:        uuid.New(),
+				Event:     event,
+				CreatedAt: time.Now().UTC(),
+				Data:      data,
+			}); err != nil {
+				return err
+			}
+		}
+
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return tx.Create(&deliveries).Error
+}
+
+// snippetEvent fills the event data every snippet event shares
+func snippetEvent(snippet *models.Snippet) WebhookEventData {
+	return WebhookEventData{
+		SnippetID: snippet.ID,
+		Title:     snippet.Title,
+		Kind:      snippet.Kind,
+	}
+}
+
+func subscribes(events, event string) bool {
+	for _, subscribed := range strings.Split(events, ",") {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
@@ -1,62 +1,350 @@
 package tasks
 
 import (
-	"log"
-	"os"
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/metrics"
 	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/services"
+	"github.com/direwen/flashpaper/internal/storage"
+	"github.com/direwen/flashpaper/internal/tracing"
 )
 
-func StartJanitor() {
+type JanitorConfig struct {
+	Interval time.Duration
+	// BatchSize bounds the rows one statement deletes, so no run holds long locks
+	BatchSize int
+	// BurntGrace is how long burnt snippets are kept before they are purged
+	BurntGrace time.Duration
+	// HistoryRetention is how long tombstones are kept
+	HistoryRetention time.Duration
+	// RetiredBlobGrace is how long blobs replaced by key rotation are kept
+	RetiredBlobGrace time.Duration
+}
 
-	interval := os.Getenv("JANITOR_INTERVAL")
-	if interval == "" {
-		interval = "10s"
-	}
+// JanitorStats is what one janitor run removed
+type JanitorStats struct {
+	ExpiredSnippets int64
+	BurntSnippets   int64
+	Blobs           int64
+	BlobFailures    int64
+	Tombstones      int64
+	Tokens          int64
+}
 
-	// Parse the interval string into a duration
-	duration, err := time.ParseDuration(interval)
-	if err != nil {
-		log.Println("Failed to parse JANITOR_INTERVAL:", err)
-		return
+// janitorLastSuccess holds the end of the last run without errors, in unix nanoseconds
+var janitorLastSuccess atomic.Int64
+
+// JanitorLastSuccess returns when the janitor last ran without errors, zero before that
+func JanitorLastSuccess() time.Time {
+	nanos := janitorLastSuccess.Load()
+	if nanos == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, nanos)
+}
+
+// janitorStandby is set while another instance holds the janitor lock and does the cleaning
+var janitorStandby atomic.Bool
+
+// JanitorStandby reports whether the last run found another instance running the janitor
+func JanitorStandby() bool {
+	return janitorStandby.Load()
+}
 
-	// Create a ticker that sends a signal on its channel at the specified interval
-	ticker := time.NewTicker(duration)
+// StartJanitor deletes expired and burnt snippets, retired blobs, old tombstones
+// and expired tokens every interval until ctx is cancelled. The returned channel
+// closes once the janitor has stopped, a run in progress is abandoned between statements.
+func StartJanitor(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, cfg JanitorConfig) <-chan struct{} {
+	done := make(chan struct{})
 
-	// Run the cleanup task concurrently in a goroutine
 	go func() {
-		// Infinite loop to continuously wait for ticker signals
-		for {
-			// Block and wait for the next signal from the ticker channel
-			<-ticker.C
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
 
-			// Trigger the cleanup function to delete expired snippets
-			cleanExpiredSnippets()
+		for {
+			select {
+			case <-ctx.Done():
+				slog.Info("The Janitor is off duty")
+				return
+			case <-ticker.C:
+				runJanitor(ctx, repos, blobs, cfg)
+			}
 		}
 	}()
 
-	log.Printf("The Janitor is on duty to clean every %s.", duration)
+	slog.Info("The Janitor is on duty", "interval", cfg.Interval, "batch_size", cfg.BatchSize, "burnt_grace", cfg.BurntGrace)
+	return done
 }
 
-func cleanExpiredSnippets() {
-	// Get database connection
-	db := config.GetDB()
+// runJanitor cleans up once, unless another instance is already doing it
+func runJanitor(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, cfg JanitorConfig) {
+	ctx, span := tracing.Start(ctx, "Janitor.run")
+	start := time.Now()
+
+	var stats JanitorStats
+	leader, err := withLeaderLock(ctx, config.GetDB(), janitorLockKey, func() error {
+		return errors.Join(
+			cleanSnippets(ctx, repos, blobs, cfg, &stats),
+			cleanRetiredBlobs(ctx, blobs, cfg, &stats),
+			cleanHistory(ctx, repos, cfg, &stats),
+			cleanExpiredTokens(ctx, cfg.BatchSize, &stats),
+		)
+	})
+	tracing.End(span, err)
 
-	// Delete snippets that are expired by time
-	result := db.Where("expires_at < ?", time.Now()).Delete(&models.Snippet{})
-	if err := result.Error; err != nil {
-		log.Println("Janitor failed to clean", err)
+	switch {
+	case ctx.Err() != nil:
+		// Shutting down, the next instance to start picks up the rest
 		return
+	case err != nil:
+		janitorStandby.Store(false)
+		metrics.JanitorRuns.WithLabelValues("failure").Inc()
+		slog.Error("Janitor run failed", "error", err)
+	case !leader:
+		// Another instance holds the lock and does the work. Only its own runs say
+		// whether the janitor is healthy, so this is not counted as a success here.
+		janitorStandby.Store(true)
+		metrics.JanitorRuns.WithLabelValues("standby").Inc()
+		slog.Debug("Janitor is on standby, another instance is running it")
+		return
+	default:
+		janitorStandby.Store(false)
+		metrics.JanitorRuns.WithLabelValues("success").Inc()
+		janitorLastSuccess.Store(time.Now().UnixNano())
 	}
 
+	duration := time.Since(start)
+	metrics.JanitorRunDuration.Observe(duration.Seconds())
+
 	// Log cleanup results
-	if result.RowsAffected > 0 {
-		log.Println("Janitor cleaned", result.RowsAffected, "expired snippets.")
+	attrs := []any{
+		"expired_snippets", stats.ExpiredSnippets,
+		"burnt_snippets", stats.BurntSnippets,
+		"blobs", stats.Blobs,
+		"blob_failures", stats.BlobFailures,
+		"tombstones", stats.Tombstones,
+		"tokens", stats.Tokens,
+		"duration", duration,
+	}
+	if stats != (JanitorStats{}) {
+		slog.Info("Janitor cleaned up", attrs...)
 	} else {
-		log.Println("Janitor found no expired snippets.")
+		slog.Debug("Janitor found nothing to clean", attrs...)
 	}
+}
 
+// cleanSnippets deletes expired snippets, and burnt ones past their grace period,
+// one batch at a time
+func cleanSnippets(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, cfg JanitorConfig, stats *JanitorStats) error {
+	// Webhook events still go straight to the database
+	db := config.GetDB()
+	now := time.Now()
+
+	filter := repository.PurgeFilter{
+		ExpiredBefore: now,
+		BurntBefore:   now.Add(-cfg.BurntGrace),
+		Limit:         cfg.BatchSize,
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := repos.Snippets.ListPurgeable(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		// Delete the blobs of file snippets first, a row whose blob could not be
+		// removed is kept so the next run retries it
+		var purge []models.Snippet
+		for _, snippet := range batch {
+			if snippet.BlobKey != "" {
+				if err := blobs.Delete(ctx, snippet.BlobKey); err != nil {
+					slog.Error("Janitor failed to delete blob of snippet", "snippet_id", snippet.ID, "error", err)
+					stats.BlobFailures++
+					filter.Skip = append(filter.Skip, snippet.ID)
+					continue
+				}
+				stats.Blobs++
+			}
+			purge = append(purge, snippet)
+		}
+
+		// Delete the batch, queueing an expiry event for the snippets nobody got
+		// to read in full in the same transaction
+		err = repos.Transaction(ctx, func(ctx context.Context) error {
+			ids := make([]uuid.UUID, 0, len(purge))
+			for _, snippet := range purge {
+				ids = append(ids, snippet.ID)
+
+				// Burnt snippets were buried when they burnt, this only fills gaps
+				reason, endedAt := models.TombstoneExpired, snippet.ExpiresAt
+				if snippet.BurntAt != nil {
+					reason, endedAt = services.BurnReason(&snippet), *snippet.BurntAt
+				}
+				if err := services.BurySnippet(ctx, repos, &snippet, reason, endedAt); err != nil {
+					return err
+				}
+
+				if burnt(snippet) {
+					continue
+				}
+				if err := services.EmitSnippetEvent(repository.Conn(ctx, db), snippet.UserID, models.EventSnippetExpired, services.WebhookEventData{
+					SnippetID: snippet.ID,
+					Title:     snippet.Title,
+					Kind:      snippet.Kind,
+				}); err != nil {
+					return err
+				}
+			}
+
+			_, err := repos.Snippets.DeleteByIDs(ctx, ids)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, snippet := range purge {
+			if burnt(snippet) {
+				stats.BurntSnippets++
+			} else {
+				stats.ExpiredSnippets++
+			}
+		}
+		metrics.JanitorRowsDeleted.WithLabelValues("snippets").Add(float64(len(purge)))
+
+		if len(batch) < cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// cleanRetiredBlobs deletes the blobs key rotation replaced once RetiredBlobGrace
+// has passed, one batch at a time. A blob that could not be removed keeps its
+// row so the next run retries it.
+func cleanRetiredBlobs(ctx context.Context, blobs storage.BlobStore, cfg JanitorConfig, stats *JanitorStats) error {
+	db := config.GetDB().WithContext(ctx)
+	before := time.Now().Add(-cfg.RetiredBlobGrace)
+
+	var failed []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		query := db.Model(&models.RetiredBlob{}).Where("retired_at < ?", before)
+		if len(failed) > 0 {
+			query = query.Where("blob_key NOT IN ?", failed)
+		}
+		var keys []string
+		if err := query.Order("retired_at").Limit(cfg.BatchSize).Pluck("blob_key", &keys).Error; err != nil {
+			return err
+		}
+
+		var deleted []string
+		for _, key := range keys {
+			if err := blobs.Delete(ctx, key); err != nil {
+				slog.Error("Janitor failed to delete retired blob", "blob_key", key, "error", err)
+				stats.BlobFailures++
+				failed = append(failed, key)
+				continue
+			}
+			stats.Blobs++
+			deleted = append(deleted, key)
+		}
+
+		if len(deleted) > 0 {
+			if err := db.Where("blob_key IN ?", deleted).Delete(&models.RetiredBlob{}).Error; err != nil {
+				return err
+			}
+			metrics.JanitorRowsDeleted.WithLabelValues("retired_blobs").Add(float64(len(deleted)))
+		}
+
+		if len(keys) < cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+// cleanHistory drops the tombstones that outlived HistoryRetention, one batch at a time
+func cleanHistory(ctx context.Context, repos repository.Repositories, cfg JanitorConfig, stats *JanitorStats) error {
+	before := time.Now().Add(-cfg.HistoryRetention)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		deleted, err := repos.Tombstones.DeleteEndedBefore(ctx, before, cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		stats.Tombstones += deleted
+		metrics.JanitorRowsDeleted.WithLabelValues("snippet_tombstones").Add(float64(deleted))
+		if deleted < int64(cfg.BatchSize) {
+			return nil
+		}
+	}
+}
+
+// burnt tells whether a snippet ran out of views or passphrase attempts
+func burnt(snippet models.Snippet) bool {
+	return snippet.BurntAt != nil || snippet.CurrentViews >= snippet.MaxViews
+}
+
+// cleanExpiredTokens drops refresh tokens, denylist entries, login challenges and
+// mailed tokens nobody can use anymore, one batch at a time
+func cleanExpiredTokens(ctx context.Context, batchSize int, stats *JanitorStats) error {
+	db := config.GetDB().WithContext(ctx)
+	now := time.Now()
+
+	expiring := []struct {
+		table string
+		key   string
+		model any
+	}{
+		{"refresh_tokens", "id", &models.RefreshToken{}},
+		{"revoked_tokens", "token_id", &models.RevokedToken{}},
+		{"mfa_challenges", "id", &models.MFAChallenge{}},
+		{"user_tokens", "id", &models.UserToken{}},
+	}
+
+	var problems []error
+	for _, expired := range expiring {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			batch := db.Model(expired.model).Select(expired.key).Where("expires_at < ?", now).Limit(batchSize)
+			result := db.Where(expired.key+" IN (?)", batch).Delete(expired.model)
+			if result.Error != nil {
+				slog.Error("Janitor failed to clean expired rows", "table", expired.table, "error", result.Error)
+				problems = append(problems, result.Error)
+				break
+			}
+
+			stats.Tokens += result.RowsAffected
+			metrics.JanitorRowsDeleted.WithLabelValues(expired.table).Add(float64(result.RowsAffected))
+			if result.RowsAffected < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return errors.Join(problems...)
 }
@@ -0,0 +1,44 @@
+package tasks
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Arbitrary keys of the Postgres advisory locks held by the instance running a task
+const (
+	janitorLockKey = 7270331102
+	rekeyLockKey   = 7270331103
+)
+
+// withLeaderLock runs fn while holding the advisory lock key, so only one
+// instance runs the task at a time. It reports false without running fn when
+// another instance holds it. SQLite databases have a single instance and no lock.
+func withLeaderLock(ctx context.Context, db *gorm.DB, key int64, fn func() error) (bool, error) {
+	if db.Dialector.Name() != "postgres" {
+		return true, fn()
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return false, err
+	}
+	// Session locks belong to a connection, hold one until the run is over
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+
+	return true, fn()
+}
@@ -0,0 +1,344 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/internal/repository"
+	"github.com/direwen/flashpaper/internal/storage"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+// rekeyRetryAfter is how long a row that could not be re-encrypted is skipped
+// before the next attempt, a key added back to the keyring may fix it
+const rekeyRetryAfter = time.Hour
+
+// StartKeyRotation periodically re-encrypts live snippets, webhook secrets and
+// TOTP secrets that are still sealed with a retired key, so old keys can be
+// dropped from ENCRYPTION_KEYS. It stops once ctx is cancelled and the returned
+// channel closes when it has.
+func StartKeyRotation(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, interval time.Duration, batchSize int) <-chan struct{} {
+	done := make(chan struct{})
+	skipped := skipSet{}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// Run once right away so a fresh rotation starts without waiting a full interval
+		runKeyRotation(ctx, repos, blobs, batchSize, skipped)
+
+		for {
+			select {
+			case <-ctx.Done():
+				slog.Info("Key rotation stopped")
+				return
+			case <-ticker.C:
+				runKeyRotation(ctx, repos, blobs, batchSize, skipped)
+			}
+		}
+	}()
+
+	slog.Info("Key rotation is scheduled", "batch_size", batchSize, "interval", interval)
+	return done
+}
+
+// runKeyRotation re-encrypts one batch of each kind, unless another instance is already doing it
+func runKeyRotation(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, batchSize int, skipped skipSet) {
+	leader, err := withLeaderLock(ctx, config.GetDB(), rekeyLockKey, func() error {
+		reencryptSnippets(ctx, repos, blobs, batchSize, skipped)
+		reencryptSecrets(ctx, webhookSecrets, batchSize, skipped)
+		reencryptSecrets(ctx, totpSecrets(repos), batchSize, skipped)
+		return nil
+	})
+	switch {
+	case ctx.Err() != nil:
+		return
+	case err != nil:
+		slog.Error("Key rotation failed to take its lock", "error", err)
+	case !leader:
+		slog.Debug("Key rotation is on standby, another instance is running it")
+	}
+}
+
+// skipSet holds the rows key rotation failed on until they are due for a retry,
+// so broken rows don't fill every batch and the set never outlives rekeyRetryAfter
+type skipSet map[uuid.UUID]time.Time
+
+func (s skipSet) add(id uuid.UUID) {
+	s[id] = time.Now().Add(rekeyRetryAfter)
+}
+
+// ids forgets the rows due for a retry and returns the ones still skipped
+func (s skipSet) ids() []uuid.UUID {
+	now := time.Now()
+	ids := make([]uuid.UUID, 0, len(s))
+	for id, until := range s {
+		if now.After(until) {
+			delete(s, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func reencryptSnippets(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, batchSize int, skipped skipSet) {
+	activeID, err := utils.ActiveKeyID()
+	if err != nil {
+		slog.Error("Key rotation failed to load keyring", "error", err)
+		return
+	}
+
+	// Find live snippets not yet tagged with the active key
+	ids, err := repos.Snippets.ListStale(ctx, repository.StaleFilter{
+		KeyID: activeID,
+		Now:   time.Now(),
+		Skip:  skipped.ids(),
+		Limit: batchSize,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Key rotation failed to find snippets", "error", err)
+		}
+		return
+	}
+
+	rotated := 0
+	for _, id := range ids {
+		if err := reencryptSnippet(ctx, repos, blobs, id); err != nil {
+			// Shutting down, the row is fine and the next run picks it up
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Error("Key rotation failed for snippet", "snippet_id", id, "error", err)
+			skipped.add(id)
+			continue
+		}
+		rotated++
+	}
+
+	if rotated > 0 {
+		slog.Info("Key rotation re-encrypted snippets", "count", rotated, "key_id", activeID)
+	}
+}
+
+func reencryptSnippet(ctx context.Context, repos repository.Repositories, blobs storage.BlobStore, id uuid.UUID) error {
+	current, err := repos.Snippets.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// A file's blob is always sealed with the same key as its manifest, rotate both
+	// together. Copying it takes as long as the file is big, so the new blob is
+	// written before the row is locked and the lock only covers the swap.
+	var newBlobKey string
+	if current.Kind == models.KindFile {
+		if newBlobKey, err = reencryptBlob(ctx, blobs, current.BlobKey); err != nil {
+			return err
+		}
+	}
+
+	swapped := false
+	err = repos.Transaction(ctx, func(ctx context.Context) error {
+		// Hold the row so a concurrent reveal never reads a half rotated value
+		snippet, err := repos.Snippets.GetForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// It may have burnt, or been rotated by someone else, while the blob was copied
+		if snippet.BurntAt != nil || snippet.BlobKey != current.BlobKey {
+			return nil
+		}
+		stale, err := utils.NeedsRotation(snippet.Content)
+		if err != nil || !stale {
+			return err
+		}
+
+		plainText, err := utils.Decrypt(snippet.Content)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := utils.Encrypt(plainText)
+		if err != nil {
+			return err
+		}
+
+		if newBlobKey != "" {
+			// A reveal that consumed its view before the swap may still be about to
+			// stream the old blob, the janitor deletes it after RETIRED_BLOB_GRACE
+			retired := &models.RetiredBlob{BlobKey: snippet.BlobKey, RetiredAt: time.Now()}
+			if err := repository.Conn(ctx, config.GetDB()).Create(retired).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := repos.Snippets.Reseal(ctx, id, encrypted, newBlobKey); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+
+	// A copy the row never pointed at belongs to nobody
+	if newBlobKey != "" && !swapped {
+		if err := blobs.Delete(context.WithoutCancel(ctx), newBlobKey); err != nil {
+			slog.Error("Key rotation failed to delete unused blob", "blob_key", newBlobKey, "error", err)
+		}
+	}
+
+	return err
+}
+
+// sealedSecrets is a column of small keyring encrypted secrets (webhook signing
+// secrets, TOTP secrets) that key rotation moves to the active key
+type sealedSecrets struct {
+	label string
+	// list returns one batch of rows not sealed with the active key
+	list func(ctx context.Context, filter repository.StaleFilter) ([]uuid.UUID, error)
+	// reseal replaces the secret of a row with what reseal makes of it, holding the row meanwhile
+	reseal func(ctx context.Context, id uuid.UUID, reseal func(sealed string) (string, error)) error
+}
+
+// webhookSecrets reaches the webhooks table directly, it has no repository
+var webhookSecrets = sealedSecrets{
+	label: "webhook secrets",
+	list: func(ctx context.Context, filter repository.StaleFilter) ([]uuid.UUID, error) {
+		query := config.GetDB().WithContext(ctx).
+			Model(&models.Webhook{}).
+			Where("secret <> ''").
+			Where(repository.NotSealedWith("secret", filter.KeyID))
+		if len(filter.Skip) > 0 {
+			query = query.Where("id NOT IN ?", filter.Skip)
+		}
+
+		var ids []uuid.UUID
+		err := query.Order("created_at").Limit(filter.Limit).Pluck("id", &ids).Error
+		return ids, err
+	},
+	reseal: func(ctx context.Context, id uuid.UUID, reseal func(sealed string) (string, error)) error {
+		return config.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var webhook models.Webhook
+			if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+				Select("id", "secret").
+				Where("id = ?", id).
+				First(&webhook).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return repository.ErrNotFound
+				}
+				return err
+			}
+
+			sealed, err := reseal(webhook.Secret)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&webhook).Update("secret", sealed).Error
+		})
+	},
+}
+
+func totpSecrets(repos repository.Repositories) sealedSecrets {
+	return sealedSecrets{
+		label:  "totp secrets",
+		list:   repos.Users.ListStaleTOTP,
+		reseal: repos.Users.ResealTOTP,
+	}
+}
+
+// reencryptSecrets moves one batch of a column of small secrets to the active key
+func reencryptSecrets(ctx context.Context, secrets sealedSecrets, batchSize int, skipped skipSet) {
+	activeID, err := utils.ActiveKeyID()
+	if err != nil {
+		slog.Error("Key rotation failed to load keyring", "error", err)
+		return
+	}
+
+	ids, err := secrets.list(ctx, repository.StaleFilter{
+		KeyID: activeID,
+		Skip:  skipped.ids(),
+		Limit: batchSize,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Key rotation failed to find "+secrets.label, "error", err)
+		}
+		return
+	}
+
+	rotated := 0
+	for _, id := range ids {
+		err := secrets.reseal(ctx, id, func(sealed string) (string, error) {
+			// Rotated by someone else in the meantime
+			stale, err := utils.NeedsRotation(sealed)
+			if err != nil || !stale {
+				return sealed, err
+			}
+
+			secret, err := utils.Decrypt(sealed)
+			if err != nil {
+				return "", err
+			}
+			return utils.Encrypt(secret)
+		})
+		// Deleted in the meantime
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Error("Key rotation failed for "+secrets.label, "row_id", id, "error", err)
+			skipped.add(id)
+			continue
+		}
+		rotated++
+	}
+
+	if rotated > 0 {
+		slog.Info("Key rotation re-encrypted "+secrets.label, "count", rotated, "key_id", activeID)
+	}
+}
+
+// reencryptBlob streams a blob through decrypt and encrypt into a new blob and returns its key
+func reencryptBlob(ctx context.Context, blobs storage.BlobStore, blobKey string) (string, error) {
+	src, err := blobs.Open(ctx, blobKey)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	// decrypt -> pipe -> encrypt -> pipe -> blob store, nothing is buffered in full
+	plainR, plainW := io.Pipe()
+	go func() {
+		plainW.CloseWithError(utils.DecryptStream(plainW, src))
+	}()
+
+	sealedR, sealedW := io.Pipe()
+	go func() {
+		sealedW.CloseWithError(utils.EncryptStream(sealedW, plainR))
+	}()
+
+	newBlobKey := uuid.NewString()
+	if _, err := blobs.Put(ctx, newBlobKey, sealedR); err != nil {
+		sealedR.CloseWithError(err)
+		plainR.CloseWithError(err)
+		return "", err
+	}
+
+	return newBlobKey, nil
+}
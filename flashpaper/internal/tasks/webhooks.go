@@ -0,0 +1,261 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/direwen/flashpaper/internal/config"
+	"github.com/direwen/flashpaper/internal/models"
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+const (
+	webhookBatchSize = 50
+	// webhookTimeout bounds one delivery, connect and response included
+	webhookTimeout = 10 * time.Second
+	// How long a claimed delivery is hidden from other dispatchers while it is being sent.
+	// A batch is sent one delivery at a time, so the lease covers a batch that times out on every endpoint.
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+	// Retries wait 30s, 1m, 2m, ... doubling up to the cap
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = 6 * time.Hour
+)
+
+// StartWebhookDispatcher periodically sends queued webhook deliveries, retrying
+// failures with exponential backoff until WEBHOOK_MAX_ATTEMPTS is reached, until
+// ctx is cancelled. The returned channel closes once the dispatcher has stopped.
+// allowInsecure lets deliveries reach loopback and private addresses (local development).
+func StartWebhookDispatcher(ctx context.Context, interval time.Duration, maxAttempts int, allowInsecure bool) <-chan struct{} {
+	done := make(chan struct{})
+	client := newWebhookClient(allowInsecure)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				slog.Info("Webhook dispatcher stopped")
+				return
+			case <-ticker.C:
+				dispatchWebhooks(ctx, client, maxAttempts)
+			}
+		}
+	}()
+
+	slog.Info("Webhook dispatcher is scheduled", "interval", interval, "max_attempts", maxAttempts)
+	return done
+}
+
+func dispatchWebhooks(ctx context.Context, client *http.Client, maxAttempts int) {
+	db := config.GetDB().WithContext(ctx)
+
+	deliveries, err := claimDeliveries(db)
+	if err != nil {
+		slog.Error("Webhook dispatcher failed to claim deliveries", "error", err)
+		return
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	webhookIDs := make([]uuid.UUID, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		webhookIDs = append(webhookIDs, delivery.WebhookID)
+	}
+	var webhooks []models.Webhook
+	if err := db.Where("id IN ?", webhookIDs).Find(&webhooks).Error; err != nil {
+		slog.Error("Webhook dispatcher failed to load webhooks", "error", err)
+		return
+	}
+	byID := make(map[uuid.UUID]models.Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		byID[webhook.ID] = webhook
+	}
+
+	for _, delivery := range deliveries {
+		// Shutting down, the unsent deliveries go out again once their lease runs out
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The webhook was deleted after the delivery was claimed, the cascade removes the row
+		webhook, ok := byID[delivery.WebhookID]
+		if !ok {
+			continue
+		}
+
+		statusCode, sendErr := sendDelivery(ctx, client, webhook, delivery)
+		if ctx.Err() != nil {
+			// An interrupted send is not the endpoint's failure, leave the attempt uncounted
+			return
+		}
+		if err := recordAttempt(db, delivery, statusCode, sendErr, maxAttempts); err != nil {
+			slog.Error("Webhook dispatcher failed to record delivery", "delivery_id", delivery.ID, "error", err)
+		}
+	}
+}
+
+// claimDeliveries picks due deliveries and leases them, so several API instances
+// can run the dispatcher without sending the same delivery twice
+func claimDeliveries(db *gorm.DB) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{
+			Strength: clause.LockingStrengthUpdate,
+			Options:  clause.LockingOptionsSkipLocked,
+		}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").
+			Limit(webhookBatchSize).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(webhookLease)).Error
+	})
+
+	return deliveries, err
+}
+
+// sendDelivery POSTs the payload signed with the webhook's secret and returns the response status
+func sendDelivery(ctx context.Context, client *http.Client, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	secret, err := utils.Decrypt(webhook.Secret)
+	if err != nil {
+		return 0, errors.New("failed to decrypt webhook secret")
+	}
+
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FlashPaper-Webhook/1.0")
+	req.Header.Set("X-FlashPaper-Event", delivery.Event)
+	req.Header.Set("X-FlashPaper-Delivery", delivery.ID.String())
+	req.Header.Set("X-FlashPaper-Timestamp", timestamp)
+	req.Header.Set("X-FlashPaper-Signature", "sha256="+utils.SignWebhook(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little so the connection can be reused, the body itself is ignored
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// recordAttempt writes the outcome of one attempt to the delivery log and schedules the retry
+func recordAttempt(db *gorm.DB, delivery models.WebhookDelivery, statusCode int, sendErr error, maxAttempts int) error {
+	attempts := delivery.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":         attempts,
+		"last_status_code": statusCode,
+		"last_error":       "",
+	}
+
+	switch {
+	case sendErr == nil:
+		updates["status"] = models.DeliveryDelivered
+		updates["delivered_at"] = time.Now()
+	case attempts >= maxAttempts:
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = truncate(sendErr.Error(), 500)
+		slog.Warn("Webhook delivery failed for good", "delivery_id", delivery.ID, "attempts", attempts, "error", sendErr)
+	default:
+		updates["last_error"] = truncate(sendErr.Error(), 500)
+		updates["next_attempt_at"] = time.Now().Add(webhookBackoff(attempts))
+	}
+
+	return db.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+}
+
+// webhookBackoff is the wait before the retry that follows the given number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBackoffBase
+	for i := 1; i < attempts && backoff < webhookBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookBackoffMax {
+		backoff = webhookBackoffMax
+	}
+	return backoff
+}
+
+// newWebhookClient builds the HTTP client for deliveries. Webhook URLs are user
+// supplied, so unless allowInsecure is set the client refuses to connect to
+// loopback, private and link-local addresses (checked after DNS resolution)
+// and never follows redirects.
+func newWebhookClient(allowInsecure bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowInsecure {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
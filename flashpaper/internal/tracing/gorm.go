@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// GormPlugin opens a span per query, as a child of the context the query was
+// made with (db.WithContext). Time spent waiting on a row lock shows up in the
+// span of the statement that waited. The statement is recorded with its
+// placeholders, never with the values bound to them.
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+
+	system := db.Dialector.Name()
+	if system == "postgres" {
+		system = "postgresql"
+	}
+
+	for _, processor := range processors {
+		operation := processor.operation
+		if err := processor.before("tracing:before_"+operation, func(tx *gorm.DB) {
+			_, span := tracer.Start(tx.Statement.Context, "db."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemNameKey.String(system), semconv.DBOperationName(operation)),
+			)
+			tx.InstanceSet(spanKey, span)
+		}); err != nil {
+			return err
+		}
+
+		if err := processor.after("tracing:after_"+operation, endQuery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func endQuery(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	if tx.Statement.Table != "" {
+		span.SetAttributes(semconv.DBCollectionName(tx.Statement.Table))
+	}
+	span.SetAttributes(
+		semconv.DBQueryText(tx.Statement.SQL.String()),
+		semconv.DBResponseReturnedRows(int(tx.RowsAffected)),
+	)
+
+	// A lookup that finds nothing is an answer, not a failure
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import "strings"
+
+// Link unfurlers from chat apps and social networks. They fetch every URL that
+// is pasted into a conversation, which used to burn one-time links.
+var linkPreviewAgents = []string{
+	"slackbot",
+	"slack-imgproxy",
+	"microsoftpreview",
+	"skypeuripreview",
+	"teams",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"linkedinbot",
+	"mattermost",
+	"redditbot",
+	"embedly",
+	"googlebot",
+	"bingbot",
+	"applebot",
+	"iframely",
+	"bitlybot",
+	"vkshare",
+	"pinterest",
+}
+
+// IsLinkPreviewBot reports whether the user agent belongs to a known link preview bot
+func IsLinkPreviewBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, agent := range linkPreviewAgents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,18 +6,159 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
-	"os"
+	"regexp"
+	"strings"
+	"sync"
 )
 
+// Keyring holds every AES-256 key that content may have been encrypted with.
+// Only the active key is used for new ciphertexts, older keys are decrypt-only
+// until the re-encryption task has migrated everything off them.
+type Keyring struct {
+	activeID string
+	order    []string
+	keys     map[string]cipher.AEAD
+}
+
+var (
+	keyring   *Keyring
+	keyringMu sync.RWMutex
+)
+
+// Key IDs end up as a prefix of stored ciphertexts, so keep them boring
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// defaultKeyID is used when only the legacy single ENCRYPTION_KEY is configured
+const defaultKeyID = "default"
+
+// NewKeyring builds a keyring from raw 32-byte keys. ids controls the order in
+// which keys are tried for untagged (pre-keyring) ciphertexts.
+func NewKeyring(activeID string, ids []string, rawKeys map[string][]byte) (*Keyring, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+
+	k := &Keyring{
+		activeID: activeID,
+		keys:     make(map[string]cipher.AEAD, len(ids)),
+	}
+
+	for _, id := range ids {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+
+		gcm, err := newGCM(rawKeys[id])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = gcm
+		k.order = append(k.order, id)
+	}
+
+	if _, ok := k.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", activeID)
+	}
+
+	// Always try the active key first for untagged ciphertexts
+	k.order = append([]string{activeID}, removeString(k.order, activeID)...)
+
+	return k, nil
+}
+
+// ParseKeyring builds a keyring from the configured keys without installing it.
+//
+// keys is a comma separated list of "id:key" pairs and activeID names the one
+// used for encryption (defaults to the first entry). Without keys the legacy
+// single legacyKey is used.
+func ParseKeyring(keys, activeID, legacyKey string) (*Keyring, error) {
+	var ids []string
+	rawKeys := map[string][]byte{}
+
+	if keys != "" {
+		for _, entry := range strings.Split(keys, ",") {
+			id, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid ENCRYPTION_KEYS entry %q: expected id:key", id)
+			}
+			ids = append(ids, id)
+			rawKeys[id] = []byte(key)
+		}
+	} else {
+		if legacyKey == "" {
+			return nil, errors.New("set ENCRYPTION_KEY or ENCRYPTION_KEYS")
+		}
+		ids = []string{defaultKeyID}
+		rawKeys[defaultKeyID] = []byte(legacyKey)
+	}
+
+	if activeID == "" {
+		activeID = ids[0]
+	}
+
+	return NewKeyring(activeID, ids, rawKeys)
+}
+
+// LoadKeyring parses the configured keys (see ParseKeyring) and installs them
+func LoadKeyring(keys, activeID, legacyKey string) error {
+	k, err := ParseKeyring(keys, activeID, legacyKey)
+	if err != nil {
+		return err
+	}
+
+	SetKeyring(k)
+	return nil
+}
+
+// SetKeyring replaces the process wide keyring
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+// ActiveKeyID returns the ID of the key currently used for encryption
+func ActiveKeyID() (string, error) {
+	k, err := getKeyring()
+	if err != nil {
+		return "", err
+	}
+	return k.activeID, nil
+}
+
+// KeyIDOf returns the key ID a ciphertext was tagged with, or "" for
+// ciphertexts written before the keyring existed
+func KeyIDOf(cryptoText string) string {
+	id, _, ok := strings.Cut(cryptoText, ":")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// NeedsRotation reports whether a ciphertext is not encrypted with the active key
+func NeedsRotation(cryptoText string) (bool, error) {
+	activeID, err := ActiveKeyID()
+	if err != nil {
+		return false, err
+	}
+	return KeyIDOf(cryptoText) != activeID, nil
+}
+
 func Encrypt(plainText string) (string, error) {
-	// Create GCM (Galois/Counter Mode) wrapper around the cipher
-	// GCM provides both encryption AND authentication (prevents tampering)
-	gcm, err := getGCM()
+	k, err := getKeyring()
 	if err != nil {
 		return "", err
 	}
 
+	// GCM (Galois/Counter Mode) provides both encryption AND authentication (prevents tampering)
+	gcm := k.keys[k.activeID]
+
 	// Create a unique nonce (number used once)
 	nonce := make([]byte, gcm.NonceSize())
 	// Generate random bytes using crypto/rand (secure random source)
@@ -29,49 +170,73 @@ func Encrypt(plainText string) (string, error) {
 	// Format: [nonce][encrypted data][authentication tag]
 	cipherText := gcm.Seal(nonce, nonce, []byte(plainText), nil)
 
-	// Convert binary data to base64 string for easy storage/transmission
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+	// Convert binary data to base64 string and tag it with the key ID
+	// Format: <key id>:<base64>
+	return k.activeID + ":" + base64.StdEncoding.EncodeToString(cipherText), nil
 }
 
 func Decrypt(cryptoText string) (string, error) {
-
-	// Decode base64 string back to binary data
-	cipherText, err := base64.StdEncoding.DecodeString(cryptoText)
+	k, err := getKeyring()
 	if err != nil {
 		return "", err
 	}
 
-	// Get the GCM cipher instance
-	gcm, err := getGCM()
+	// Tagged ciphertexts name their key, legacy ones have to try every key
+	candidates := k.order
+	encoded := cryptoText
+	if id, rest, ok := strings.Cut(cryptoText, ":"); ok {
+		if _, known := k.keys[id]; !known {
+			return "", fmt.Errorf("unknown encryption key %q", id)
+		}
+		candidates = []string{id}
+		encoded = rest
+	}
+
+	// Decode base64 string back to binary data
+	cipherText, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return "", err
 	}
 
+	for _, id := range candidates {
+		plainText, err := open(k.keys[id], cipherText)
+		if err == nil {
+			// Convert decrypted bytes back to string
+			return string(plainText), nil
+		}
+	}
+
+	return "", errors.New("decryption failed")
+}
+
+// open splits the nonce off a sealed message and authenticates/decrypts the rest
+func open(gcm cipher.AEAD, cipherText []byte) ([]byte, error) {
 	// Get the nonce size (typically 12 bytes for GCM)
 	nonceSize := gcm.NonceSize()
 
 	// Ensure the ciphertext is long enough to contain the nonce
 	if len(cipherText) < nonceSize {
-		return "", errors.New("ciphertext too short")
+		return nil, errors.New("ciphertext too short")
 	}
 
 	// Extract the nonce from the beginning and the encrypted data from the rest
 	nonce, cipherText := cipherText[:nonceSize], cipherText[nonceSize:]
 
-	// Decrypt the data using GCM
-	plainText, err := gcm.Open(nil, nonce, cipherText, nil)
-	if err != nil {
-		return "", err
-	}
+	return gcm.Open(nil, nonce, cipherText, nil)
+}
 
-	// Convert decrypted bytes back to string
-	return string(plainText), nil
+// getKeyring returns the installed keyring, LoadKeyring runs at boot
+func getKeyring() (*Keyring, error) {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	if keyring == nil {
+		return nil, errors.New("encryption keyring is not loaded")
+	}
+	return keyring, nil
 }
 
-// getGCM creates and returns a Galois/Counter Mode (GCM) cipher for AES encryption
-func getGCM() (cipher.AEAD, error) {
-	// Load the 32-byte encryption key from environment variable
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
+// newGCM creates and returns a Galois/Counter Mode (GCM) cipher for AES encryption
+func newGCM(key []byte) (cipher.AEAD, error) {
 	if len(key) != 32 {
 		return nil, errors.New("invalid key size: must be 32 bytes")
 	}
@@ -85,3 +250,13 @@ func getGCM() (cipher.AEAD, error) {
 	// Return GCM mode cipher for authenticated encryption
 	return cipher.NewGCM(block)
 }
+
+func removeString(list []string, target string) []string {
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != target {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+const (
+	keyA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	keyB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      string
+		activeID  string
+		legacyKey string
+		want      string // active key ID, "" when parsing must fail
+	}{
+		{name: "legacy key only", legacyKey: keyA, want: "default"},
+		{name: "first entry is active", keys: "k1:" + keyA + ", k2:" + keyB, want: "k1"},
+		{name: "explicit active key", keys: "k1:" + keyA + ",k2:" + keyB, activeID: "k2", want: "k2"},
+		{name: "keys win over the legacy key", keys: "k1:" + keyA, legacyKey: keyB, want: "k1"},
+		{name: "no key at all"},
+		{name: "entry without id", keys: keyA},
+		{name: "invalid key id", keys: "k 1:" + keyA},
+		{name: "duplicate key id", keys: "k1:" + keyA + ",k1:" + keyB},
+		{name: "short key", keys: "k1:tooshort"},
+		{name: "unknown active key", keys: "k1:" + keyA, activeID: "k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := utils.ParseKeyring(tt.keys, tt.activeID, tt.legacyKey)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatal("got a keyring, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			utils.SetKeyring(keyring)
+			activeID, err := utils.ActiveKeyID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if activeID != tt.want {
+				t.Fatalf("got active key %q, want %q", activeID, tt.want)
+			}
+		})
+	}
+}
+
+// loadKeyring installs a keyring for the rest of the test
+func loadKeyring(t *testing.T, keys, activeID string) {
+	t.Helper()
+
+	if err := utils.LoadKeyring(keys, activeID, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptTagsCiphertextWithActiveKey(t *testing.T) {
+	loadKeyring(t, "old:"+keyA+",new:"+keyB, "new")
+
+	sealed, err := utils.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.KeyIDOf(sealed) != "new" {
+		t.Fatalf("got key id %q, want new", utils.KeyIDOf(sealed))
+	}
+	if stale, err := utils.NeedsRotation(sealed); err != nil || stale {
+		t.Fatalf("fresh ciphertext: got stale %v, err %v", stale, err)
+	}
+
+	plainText, err := utils.Decrypt(sealed)
+	if err != nil || plainText != "hunter2" {
+		t.Fatalf("got %q, %v", plainText, err)
+	}
+}
+
+func TestDecryptOlderKeys(t *testing.T) {
+	// Sealed while "old" was the only key
+	loadKeyring(t, "old:"+keyA, "")
+	tagged, err := utils.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ciphertexts from before the keyring carry no key ID
+	_, untagged, _ := strings.Cut(tagged, ":")
+
+	loadKeyring(t, "new:"+keyB+",old:"+keyA, "new")
+
+	for name, sealed := range map[string]string{"tagged": tagged, "untagged": untagged} {
+		t.Run(name, func(t *testing.T) {
+			plainText, err := utils.Decrypt(sealed)
+			if err != nil || plainText != "hunter2" {
+				t.Fatalf("got %q, %v", plainText, err)
+			}
+			if stale, err := utils.NeedsRotation(sealed); err != nil || !stale {
+				t.Fatalf("got stale %v, err %v, want it due for rotation", stale, err)
+			}
+		})
+	}
+}
+
+func TestDecryptFailsWithoutItsKey(t *testing.T) {
+	loadKeyring(t, "old:"+keyA, "")
+	tagged, err := utils.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untagged, _ := strings.Cut(tagged, ":")
+
+	// "old" was dropped from the keyring
+	loadKeyring(t, "new:"+keyB, "")
+
+	for name, sealed := range map[string]string{"tagged": tagged, "untagged": untagged} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := utils.Decrypt(sealed); err == nil {
+				t.Fatal("decrypted without the key it was sealed with")
+			}
+		})
+	}
+}
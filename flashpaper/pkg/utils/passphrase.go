@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id cost used for new passphrase seals. The parameters are stored in
+// every sealed value, so they can be raised later without breaking old snippets.
+const (
+	argonTime    uint32 = 2
+	argonMemory  uint32 = 64 * 1024
+	argonThreads uint8  = 2
+	argonKeyLen  uint32 = 32
+	argonSaltLen        = 16
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// SealWithPassphrase encrypts plainText with a key derived from the passphrase.
+// Format: argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<nonce+ciphertext>
+func SealWithPassphrase(plainText, passphrase string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := passphraseGCM(passphrase, salt, argonTime, argonMemory, argonThreads)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plainText), nil)
+
+	return fmt.Sprintf(
+		"argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	), nil
+}
+
+// OpenWithPassphrase reverses SealWithPassphrase. A wrong passphrase returns ErrWrongPassphrase.
+func OpenWithPassphrase(sealed, passphrase string) (string, error) {
+	parts := strings.Split(sealed, "$")
+	if len(parts) != 5 || parts[0] != "argon2id" {
+		return "", errors.New("invalid passphrase seal")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[1], "v=%d", &version); err != nil || version != argon2.Version {
+		return "", errors.New("unsupported argon2 version")
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time < 1 || threads < 1 {
+		return "", errors.New("invalid argon2 parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", err
+	}
+	cipherText, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := passphraseGCM(passphrase, salt, time, memory, threads)
+	if err != nil {
+		return "", err
+	}
+
+	// GCM authentication fails when the derived key is wrong
+	plainText, err := open(gcm, cipherText)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+
+	return string(plainText), nil
+}
+
+// passphraseGCM derives an AES-256 key from the passphrase with Argon2id
+func passphraseGCM(passphrase string, salt []byte, time, memory uint32, threads uint8) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, argonKeyLen)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -1,12 +1,20 @@
 package utils
 
-import "github.com/gin-gonic/gin"
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
 
 type Response struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data"`
 	Error   string      `json:"error,omitempty"`
+	// Code is the machine readable kind of Error, e.g. "expired" or "burnt". Clients
+	// branch on it, Error is for people and may change.
+	Code string `json:"code,omitempty"`
 }
 
 func SendSuccess(c *gin.Context, code int, data interface{}) {
@@ -29,12 +37,29 @@ func SendMessage(c *gin.Context, code int, message string) {
 	)
 }
 
+// SendError answers with a generic code derived from the status, e.g. "bad_request"
 func SendError(c *gin.Context, code int, err error) {
+	SendErrorCode(c, code, StatusErrorCode(code), err)
+}
+
+// SendErrorCode answers with an explicit error code
+func SendErrorCode(c *gin.Context, status int, code string, err error) {
+	message := strings.ToLower(http.StatusText(status))
+	if err != nil {
+		message = err.Error()
+	}
+
 	c.JSON(
-		code,
+		status,
 		Response{
 			Success: false,
-			Error:   err.Error(),
+			Error:   message,
+			Code:    code,
 		},
 	)
 }
+
+// StatusErrorCode is the snake_case status text, "Too Many Requests" becomes "too_many_requests"
+func StatusErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
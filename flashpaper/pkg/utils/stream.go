@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Streams are encrypted in fixed size chunks so files never have to be held in
+// memory. Every chunk is its own AES-GCM message:
+//
+//	header: "FPS1" | key id length (1 byte) | key id | nonce prefix (8 bytes)
+//	chunk:  final flag (1 byte) | sealed length (4 bytes) | sealed chunk
+//
+// The chunk nonce is the prefix followed by a 4 byte counter, and the header
+// plus final flag are authenticated as additional data, so chunks cannot be
+// reordered, swapped between files or truncated without detection.
+const (
+	streamMagic      = "FPS1"
+	streamChunkSize  = 64 * 1024
+	streamPrefixSize = 8
+)
+
+// EncryptStream encrypts everything read from src with the active key and writes it to dst
+func EncryptStream(dst io.Writer, src io.Reader) error {
+	k, err := getKeyring()
+	if err != nil {
+		return err
+	}
+	gcm := k.keys[k.activeID]
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return err
+	}
+
+	header := streamHeader(k.activeID, prefix)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	// Read one chunk ahead so we know which chunk is the final one
+	current := make([]byte, streamChunkSize)
+	next := make([]byte, streamChunkSize)
+
+	n, err := io.ReadFull(src, current)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	eof := err != nil
+
+	for counter := uint32(0); ; counter++ {
+		var m int
+		if !eof {
+			m, err = io.ReadFull(src, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			// A short read means src is drained, a zero read means current was the last chunk
+			if err != nil && m == 0 {
+				eof = true
+			}
+		}
+		final := eof
+
+		if err := writeChunk(dst, gcm, header, prefix, counter, current[:n], final); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+
+		if m < streamChunkSize {
+			eof = true
+		}
+		current, next = next, current
+		n = m
+	}
+}
+
+// DecryptStream authenticates and decrypts a stream produced by EncryptStream into dst.
+// Each chunk is verified before it is written, so dst never receives forged data.
+func DecryptStream(dst io.Writer, src io.Reader) error {
+	k, err := getKeyring()
+	if err != nil {
+		return err
+	}
+
+	// Read the fixed part of the header to learn the key id length
+	fixed := make([]byte, len(streamMagic)+1)
+	if _, err := io.ReadFull(src, fixed); err != nil {
+		return errors.New("invalid encrypted stream")
+	}
+	if string(fixed[:len(streamMagic)]) != streamMagic {
+		return errors.New("invalid encrypted stream")
+	}
+
+	rest := make([]byte, int(fixed[len(streamMagic)])+streamPrefixSize)
+	if _, err := io.ReadFull(src, rest); err != nil {
+		return errors.New("invalid encrypted stream")
+	}
+
+	keyID := string(rest[:len(rest)-streamPrefixSize])
+	prefix := rest[len(rest)-streamPrefixSize:]
+	gcm, ok := k.keys[keyID]
+	if !ok {
+		return fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	header := streamHeader(keyID, prefix)
+
+	frame := make([]byte, 5)
+	sealed := make([]byte, 0, streamChunkSize+gcm.Overhead())
+
+	for counter := uint32(0); ; counter++ {
+		if _, err := io.ReadFull(src, frame); err != nil {
+			// Running out of data before the final chunk means the stream was truncated
+			return errors.New("encrypted stream truncated")
+		}
+
+		final := frame[0] == 1
+		size := binary.BigEndian.Uint32(frame[1:])
+		if size > uint32(streamChunkSize+gcm.Overhead()) {
+			return errors.New("encrypted stream chunk too large")
+		}
+
+		sealed = sealed[:size]
+		if _, err := io.ReadFull(src, sealed); err != nil {
+			return errors.New("encrypted stream truncated")
+		}
+
+		plainText, err := gcm.Open(nil, chunkNonce(prefix, counter), sealed, chunkAAD(header, final))
+		if err != nil {
+			return errors.New("decryption failed")
+		}
+		if _, err := dst.Write(plainText); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// StreamKeyID returns the key a stream was encrypted with by reading only its header
+func StreamKeyID(src io.Reader) (string, error) {
+	fixed := make([]byte, len(streamMagic)+1)
+	if _, err := io.ReadFull(src, fixed); err != nil || string(fixed[:len(streamMagic)]) != streamMagic {
+		return "", errors.New("invalid encrypted stream")
+	}
+
+	keyID := make([]byte, int(fixed[len(streamMagic)]))
+	if _, err := io.ReadFull(src, keyID); err != nil {
+		return "", errors.New("invalid encrypted stream")
+	}
+
+	return string(keyID), nil
+}
+
+func writeChunk(dst io.Writer, gcm cipher.AEAD, header, prefix []byte, counter uint32, chunk []byte, final bool) error {
+	sealed := gcm.Seal(nil, chunkNonce(prefix, counter), chunk, chunkAAD(header, final))
+
+	frame := make([]byte, 5)
+	if final {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+
+	if _, err := dst.Write(frame); err != nil {
+		return err
+	}
+	_, err := dst.Write(sealed)
+	return err
+}
+
+func streamHeader(keyID string, prefix []byte) []byte {
+	var header bytes.Buffer
+	header.WriteString(streamMagic)
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	header.Write(prefix)
+	return header.Bytes()
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, streamPrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	return nonce
+}
+
+func chunkAAD(header []byte, final bool) []byte {
+	aad := make([]byte, len(header)+1)
+	copy(aad, header)
+	if final {
+		aad[len(header)] = 1
+	}
+	return aad
+}
@@ -0,0 +1,156 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/direwen/flashpaper/pkg/utils"
+)
+
+const chunkSize = 64 * 1024
+
+// sealStream encrypts plainText with the keyring of the test
+func sealStream(t *testing.T, plainText []byte) []byte {
+	t.Helper()
+
+	var sealed bytes.Buffer
+	if err := utils.EncryptStream(&sealed, bytes.NewReader(plainText)); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// splitStream cuts a sealed stream into its header and its framed chunks
+func splitStream(t *testing.T, sealed []byte) ([]byte, [][]byte) {
+	t.Helper()
+
+	headerSize := 4 + 1 + int(sealed[4]) + 8
+	header, rest := sealed[:headerSize], sealed[headerSize:]
+
+	var chunks [][]byte
+	for len(rest) > 0 {
+		size := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	return header, chunks
+}
+
+func joinStream(header []byte, chunks ...[]byte) []byte {
+	return slices.Concat(append([][]byte{header}, chunks...)...)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	loadKeyring(t, "k1:"+keyA, "")
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{name: "empty", size: 0, chunks: 1},
+		{name: "one byte", size: 1, chunks: 1},
+		{name: "one byte short of a chunk", size: chunkSize - 1, chunks: 1},
+		{name: "exactly one chunk", size: chunkSize, chunks: 1},
+		{name: "one byte over a chunk", size: chunkSize + 1, chunks: 2},
+		{name: "several chunks", size: 3*chunkSize + 7, chunks: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plainText := randomBytes(t, tt.size)
+			sealed := sealStream(t, plainText)
+
+			if _, chunks := splitStream(t, sealed); len(chunks) != tt.chunks {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.chunks)
+			}
+			keyID, err := utils.StreamKeyID(bytes.NewReader(sealed))
+			if err != nil || keyID != "k1" {
+				t.Fatalf("got key id %q, %v", keyID, err)
+			}
+
+			var opened bytes.Buffer
+			if err := utils.DecryptStream(&opened, bytes.NewReader(sealed)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened.Bytes(), plainText) {
+				t.Fatalf("got %d bytes back, want the %d written", opened.Len(), len(plainText))
+			}
+		})
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	loadKeyring(t, "k1:"+keyA, "")
+
+	sealed := sealStream(t, randomBytes(t, 2*chunkSize+1))
+	header, chunks := splitStream(t, sealed)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	_, otherChunks := splitStream(t, sealStream(t, randomBytes(t, 2*chunkSize+1)))
+
+	// flipped returns a copy of b with one bit of the byte at i changed
+	flipped := func(b []byte, i int) []byte {
+		b = slices.Clone(b)
+		b[i] ^= 1
+		return b
+	}
+	// markedFinal returns a copy of a chunk claiming to be the last one
+	markedFinal := func(chunk []byte) []byte {
+		chunk = slices.Clone(chunk)
+		chunk[0] = 1
+		return chunk
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{name: "final chunk dropped", stream: joinStream(header, chunks[0], chunks[1])},
+		{name: "final chunk cut short", stream: sealed[:len(sealed)-1]},
+		{name: "only the header", stream: header},
+		{name: "chunk dropped from the middle", stream: joinStream(header, chunks[0], chunks[2])},
+		{name: "chunks reordered", stream: joinStream(header, chunks[1], chunks[0], chunks[2])},
+		{name: "chunk duplicated", stream: joinStream(header, chunks[0], chunks[0], chunks[1], chunks[2])},
+		{name: "chunk from another stream", stream: joinStream(header, chunks[0], otherChunks[1], chunks[2])},
+		{name: "early chunk marked final", stream: joinStream(header, markedFinal(chunks[0]))},
+		{name: "chunk body changed", stream: joinStream(header, chunks[0], flipped(chunks[1], 20), chunks[2])},
+		{name: "magic changed", stream: flipped(sealed, 0)},
+		{name: "nonce prefix changed", stream: flipped(sealed, len(header)-1)},
+		{name: "key id changed", stream: joinStream(flipped(header, 5), chunks...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opened bytes.Buffer
+			if err := utils.DecryptStream(&opened, bytes.NewReader(tt.stream)); err == nil {
+				t.Fatal("tampered stream decrypted without an error")
+			}
+		})
+	}
+}
+
+func TestStreamNeedsItsKey(t *testing.T) {
+	loadKeyring(t, "k1:"+keyA, "")
+	sealed := sealStream(t, []byte("hunter2"))
+
+	loadKeyring(t, "k2:"+keyB, "")
+	var opened bytes.Buffer
+	if err := utils.DecryptStream(&opened, bytes.NewReader(sealed)); err == nil {
+		t.Fatal("decrypted without the key it was sealed with")
+	}
+}
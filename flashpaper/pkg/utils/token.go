@@ -1,67 +1,216 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
+	"io"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func GenerateToken(userID uuid.UUID) (string, error) {
-	// Get JWT SECRET KEY from env
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", errors.New("JWT SECRET KEY is not set")
-	}
+// AccessClaims is what a valid access token vouches for
+type AccessClaims struct {
+	UserID uuid.UUID
+	// TokenID is the jti, the key under which the token can be revoked early
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// TokenConfig is the signing secret and the lifetimes of the tokens issued here
+type TokenConfig struct {
+	Secret     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	RevealTTL  time.Duration
+}
+
+// tokenConfig is installed by ConfigureTokens at boot, nothing is signed without a secret
+var tokenConfig = TokenConfig{
+	AccessTTL:  15 * time.Minute,
+	RefreshTTL: 720 * time.Hour,
+	RevealTTL:  2 * time.Minute,
+}
+
+// ConfigureTokens replaces the process wide token settings
+func ConfigureTokens(cfg TokenConfig) {
+	tokenConfig = cfg
+}
 
-	token_expiration := os.Getenv("TOKEN_EXPIRATION")
-	if token_expiration == "" {
-		token_expiration = "24h"
+// signingKey is the HMAC key of every JWT, an empty one would accept forgeries
+func signingKey() ([]byte, error) {
+	if tokenConfig.Secret == "" {
+		return nil, errors.New("JWT SECRET KEY is not set")
 	}
+	return []byte(tokenConfig.Secret), nil
+}
 
-	token_expiration_duration, err := time.ParseDuration(token_expiration)
+// GenerateToken issues a short-lived access token. Every token carries a unique
+// jti so logout and refresh token reuse can revoke it before it expires.
+func GenerateToken(userID uuid.UUID) (string, *AccessClaims, error) {
+	secret, err := signingKey()
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	issued := &AccessClaims{
+		UserID:    userID,
+		TokenID:   uuid.NewString(),
+		ExpiresAt: time.Now().Add(tokenConfig.AccessTTL),
 	}
 
 	// Specify token claims
 	claims := jwt.MapClaims{
+		"typ":     "access",
+		"jti":     issued.TokenID,
 		"user_id": userID.String(),
-		"exp":     time.Now().Add(token_expiration_duration).Unix(),
+		"exp":     issued.ExpiresAt.Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
 	// Create a new token with the specified signing method and claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString([]byte(secret))
-}
+	signed, err := token.SignedString(secret)
+	if err != nil {
+		return "", nil, err
+	}
 
-// Validate & parse token -> returns User ID
-func ValidateToken(tokenString string) (uuid.UUID, error) {
-	secret := os.Getenv("JWT_SECRET")
+	return signed, issued, nil
+}
 
+// Validate & parse an access token. Whether its jti was revoked is up to the caller.
+func ValidateToken(tokenString string) (*AccessClaims, error) {
 	// Parse token string
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Check if the signing method is HMAC
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(secret), nil
+		return signingKey()
 	})
 
 	if err != nil || !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+		return nil, errors.New("invalid token")
+	}
+
+	// Checks the type of the claims object (MapClaims). Tokens without a jti
+	// predate revocation and are no longer accepted.
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "access" {
+		return nil, errors.New("invalid token claims")
+	}
+	tokenID, _ := claims["jti"].(string)
+	userIDStr, _ := claims["user_id"].(string)
+	if tokenID == "" || userIDStr == "" {
+		return nil, errors.New("invalid token claims")
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, errors.New("invalid token claims")
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil, errors.New("invalid token claims")
+	}
+
+	return &AccessClaims{
+		UserID:    userID,
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt.Time,
+	}, nil
+}
+
+// GenerateRefreshToken returns a random opaque refresh token, the hash to store
+// instead of it and when it expires
+func GenerateRefreshToken() (string, string, time.Time, error) {
+	token, hash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return token, hash, time.Now().Add(tokenConfig.RefreshTTL), nil
+}
+
+// GenerateOpaqueToken returns a random 256 bit token and the hash to store instead of it
+func GenerateOpaqueToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, HashToken(token), nil
+}
+
+// HashToken is how opaque tokens are stored and looked up. They are long and
+// random, so a plain SHA-256 is enough (no salt or slow hash needed).
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRevealToken issues a short-lived token that allows one snippet (or recipient link)
+// to be revealed. It is handed out by the non-consuming landing request and must accompany the reveal.
+func GenerateRevealToken(targetID uuid.UUID) (string, time.Time, error) {
+	secret, err := signingKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(tokenConfig.RevealTTL)
+	claims := jwt.MapClaims{
+		"typ":       "reveal",
+		"target_id": targetID.String(),
+		"exp":       expiresAt.Unix(),
+		"iat":       time.Now().Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(secret)
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	// Checks the type of the claims object (MapClaims)
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if userIDStr, ok := claims["user_id"].(string); ok {
-			return uuid.Parse(userIDStr)
+	return signed, expiresAt, nil
+}
+
+// ValidateRevealToken checks that the token is valid and was issued for this snippet or link
+func ValidateRevealToken(tokenString string, targetID uuid.UUID) error {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
 		}
+		return signingKey()
+	})
+	if err != nil || !token.Valid {
+		return errors.New("invalid reveal token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "reveal" || claims["target_id"] != targetID.String() {
+		return errors.New("invalid reveal token")
+	}
+
+	return nil
+}
+
+// APITokenPrefix marks personal API tokens, so they can be told apart from JWTs
+const APITokenPrefix = "fp_"
+
+// GenerateAPIToken returns a new random personal API token and the hash to store instead of it
+func GenerateAPIToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", "", err
 	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
 
-	return uuid.Nil, errors.New("invalid token claims")
+	return token, HashToken(token), nil
 }